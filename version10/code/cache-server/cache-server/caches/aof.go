@@ -0,0 +1,328 @@
+package caches
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// AofFsyncAlways 表示每次写入 AOF 之后都马上刷盘，最安全但是最慢。
+	AofFsyncAlways = "always"
+
+	// AofFsyncEverySecond 表示每秒刷盘一次，最多丢失一秒的数据。
+	AofFsyncEverySecond = "everysec"
+
+	// AofFsyncNever 表示从不主动刷盘，交给操作系统决定什么时候刷盘。
+	AofFsyncNever = "no"
+
+	// aofMagic 是 AOF 文件开头的魔数，用于识别 AOF 文件。
+	aofMagic = "KAFOAOF"
+
+	// aofVersion 是 AOF 文件的格式版本，紧跟在魔数后面。
+	// 版本 1 中数据的 Ttl 和 Ctime 的单位是秒，版本 2 开始改成了纳秒。
+	aofVersion = byte(2)
+
+	// secondsAofVersion 是 Ttl 和 Ctime 的单位还是秒的最后一个版本。
+	secondsAofVersion = byte(1)
+
+	// aofRecordHeaderSize 是每条记录头部的大小，包括 4 字节的长度和 4 字节的 CRC 校验和。
+	aofRecordHeaderSize = 8
+
+	// rewritingAofSuffix 是重写时被切换下来的旧 AOF 文件的后缀。
+	rewritingAofSuffix = ".rewriting"
+
+	// discardedAofSuffix 是从历史快照恢复时被丢弃的 AOF 文件的后缀。
+	discardedAofSuffix = ".discarded"
+)
+
+const (
+	// aofSetOp 是添加数据的操作。
+	aofSetOp = byte(1)
+
+	// aofDeleteOp 是删除数据的操作。
+	aofDeleteOp = byte(2)
+
+	// aofFlushOp 是清空缓存的操作，回放的时候会清空之前所有的数据。
+	aofFlushOp = byte(3)
+)
+
+// aofRecord 是 AOF 文件中的一条记录。
+// 添加数据的记录存储的是完整的 value，包括创建时间，所以重复回放也不会有副作用。
+type aofRecord struct {
+
+	// Op 是操作类型。
+	Op byte
+
+	// Key 是操作的 key。
+	Key string
+
+	// Value 是添加数据时的数据，删除数据时为空。
+	Value *value
+}
+
+// appendOnlyFile 是追加写的日志文件，记录着所有的写操作，用于在两次持久化之间的数据恢复。
+// 文件的格式是魔数和版本号，后面跟着一条条的记录，每条记录都是 4 字节长度、4 字节 CRC 校验和以及 gob 编码的 aofRecord。
+type appendOnlyFile struct {
+
+	// path 是 AOF 文件的路径。
+	path string
+
+	// fsync 是刷盘策略。
+	fsync string
+
+	// file 是当前正在写入的文件。
+	file *os.File
+
+	// size 是当前文件的大小。
+	size int64
+
+	// dirty 表示是否有还没有刷盘的数据。
+	dirty bool
+
+	// lock 用于保证写入的顺序和并发安全。
+	lock *sync.Mutex
+
+	// closeCh 用于通知刷盘任务退出。
+	closeCh chan struct{}
+}
+
+// openAppendOnlyFile 打开或者创建 path 对应的 AOF 文件，并按照 fsync 策略开启刷盘任务。
+func openAppendOnlyFile(path string, fsync string) (*appendOnlyFile, error) {
+
+	if fsync != AofFsyncAlways && fsync != AofFsyncEverySecond && fsync != AofFsyncNever {
+		return nil, errors.New("unknown aof fsync policy " + fsync)
+	}
+
+	aof := &appendOnlyFile{
+		path:    path,
+		fsync:   fsync,
+		lock:    &sync.Mutex{},
+		closeCh: make(chan struct{}),
+	}
+
+	if err := aof.openFile(); err != nil {
+		return nil, err
+	}
+
+	if fsync == AofFsyncEverySecond {
+		go aof.fsyncEverySecond()
+	}
+	return aof, nil
+}
+
+// openFile 以追加的方式打开 AOF 文件，如果文件是新建的就写入文件头，调用之前需要先加锁。
+func (aof *appendOnlyFile) openFile() error {
+
+	file, err := os.OpenFile(aof.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	aof.file = file
+	aof.size = info.Size()
+	if aof.size <= 0 {
+		n, err := file.Write(append([]byte(aofMagic), aofVersion))
+		if err != nil {
+			file.Close()
+			return err
+		}
+		aof.size = int64(n)
+	}
+	return nil
+}
+
+// fsyncEverySecond 每秒把还没有刷盘的数据刷到磁盘上，直到 AOF 被关闭。
+func (aof *appendOnlyFile) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			aof.lock.Lock()
+			if aof.dirty {
+				aof.file.Sync()
+				aof.dirty = false
+			}
+			aof.lock.Unlock()
+		case <-aof.closeCh:
+			return
+		}
+	}
+}
+
+// append 把一条记录追加到 AOF 文件中。
+func (aof *appendOnlyFile) append(op byte, key string, v *value) error {
+
+	record, err := encodeAofRecord(&aofRecord{Op: op, Key: key, Value: v})
+	if err != nil {
+		return err
+	}
+
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+	n, err := aof.file.Write(record)
+	aof.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	if aof.fsync == AofFsyncAlways {
+		return aof.file.Sync()
+	}
+	aof.dirty = true
+	return nil
+}
+
+// fileSize 返回当前 AOF 文件的大小。
+func (aof *appendOnlyFile) fileSize() int64 {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+	return aof.size
+}
+
+// rotate 把当前的 AOF 文件切换下来，之后的写操作都会写入到新的 AOF 文件中，用于配合持久化重写 AOF。
+// 如果上一次重写失败了，切换下来的文件还在，就不能再切换了，否则会覆盖掉它里面的数据。
+func (aof *appendOnlyFile) rotate() error {
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+
+	rewritingFile := aof.path + rewritingAofSuffix
+	if _, err := os.Stat(rewritingFile); err == nil {
+		return nil
+	}
+
+	if err := aof.file.Sync(); err != nil {
+		return err
+	}
+	aof.file.Close()
+	if err := os.Rename(aof.path, rewritingFile); err != nil {
+		aof.openFile()
+		return err
+	}
+	aof.dirty = false
+	return aof.openFile()
+}
+
+// finishRewrite 在持久化成功之后删除切换下来的 AOF 文件，因为它里面的数据都已经在持久化文件中了。
+func (aof *appendOnlyFile) finishRewrite() error {
+	err := os.Remove(aof.path + rewritingAofSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// discardAofFile 把不再需要回放的 AOF 文件重命名成带有时间戳的 .discarded 文件，以防还需要找回里面的数据。
+func discardAofFile(path string) error {
+	err := os.Rename(path, path+nowSuffix()+discardedAofSuffix)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// close 关闭 AOF 文件，关闭之前会刷盘。
+func (aof *appendOnlyFile) close() error {
+	close(aof.closeCh)
+	aof.lock.Lock()
+	defer aof.lock.Unlock()
+	aof.file.Sync()
+	return aof.file.Close()
+}
+
+// encodeAofRecord 把记录编码成 AOF 文件中的格式。
+// 每条记录都使用新的 gob 编码器，这样每条记录都是独立的，文件尾部写了一半的记录也不会影响前面的记录。
+func encodeAofRecord(record *aofRecord) ([]byte, error) {
+	buffer := bytes.NewBuffer(make([]byte, aofRecordHeaderSize, 64))
+	if err := gob.NewEncoder(buffer).Encode(record); err != nil {
+		return nil, err
+	}
+
+	data := buffer.Bytes()
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(data)-aofRecordHeaderSize))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(data[aofRecordHeaderSize:]))
+	return data, nil
+}
+
+// replayAofFile 依次读取 path 对应的 AOF 文件中的记录，并交给 apply 回放，返回这个 AOF 文件的版本，文件不存在或者没有记录的时候返回 0。
+// 进程崩溃的时候，文件尾部可能只写了一半的记录，所以读不完整或者校验和对不上的记录都当作有效日志的结尾，从这条记录开始截断。
+func replayAofFile(path string, apply func(record *aofRecord)) (byte, error) {
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	header := make([]byte, len(aofMagic)+1)
+	if _, err = io.ReadFull(reader, header); err != nil {
+		// 连文件头都没有写完整，说明这是一个还没有任何记录的文件
+		file.Close()
+		return 0, os.Truncate(path, 0)
+	}
+
+	version := header[len(aofMagic)]
+	if string(header[:len(aofMagic)]) != aofMagic || version < secondsAofVersion || version > aofVersion {
+		return 0, fmt.Errorf("%s is not a supported aof file", path)
+	}
+
+	offset := int64(len(header))
+	recordHeader := make([]byte, aofRecordHeaderSize)
+	for {
+		if _, err = io.ReadFull(reader, recordHeader); err == io.EOF {
+			return version, nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// 和持久化文件一样，长度还没有经过校验，写了一半的记录中可能是一个很大的值，所以边读边扩容，不按照这个长度分配内存
+		length := int64(binary.BigEndian.Uint32(recordHeader[0:4]))
+		payload := &bytes.Buffer{}
+		n, err := io.Copy(payload, io.LimitReader(reader, length))
+		if err != nil {
+			return 0, err
+		}
+
+		if n != length || crc32.ChecksumIEEE(payload.Bytes()) != binary.BigEndian.Uint32(recordHeader[4:8]) {
+			break
+		}
+
+		record := &aofRecord{}
+		if err = gob.NewDecoder(payload).Decode(record); err != nil {
+			return 0, fmt.Errorf("aof file %s is corrupted: %s at offset %d", path, err.Error(), offset)
+		}
+
+		if version <= secondsAofVersion && record.Value != nil {
+			record.Value.secondsToNanos()
+		}
+		apply(record)
+		offset += aofRecordHeaderSize + length
+	}
+
+	// 走到这里说明文件尾部的记录没有写完整或者已经损坏，截断之后后续的写入才能接在完整的记录后面
+	file.Close()
+	return version, os.Truncate(path, offset)
+}
@@ -0,0 +1,251 @@
+package caches
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// go test -v -run=^TestAofReplay$
+func TestAofReplay(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	options.AofFile = filepath.Join(filepath.Dir(options.DumpFile), "kafo.aof")
+	options.AofFsync = AofFsyncAlways
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		data := strconv.Itoa(i)
+		cache.Set(data, []byte(data))
+	}
+
+	// 持久化会重写 AOF，之后的写操作只存在于新的 AOF 文件中
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Delete("0")
+	cache.Set("1", []byte("one"))
+	cache.aof.close()
+
+	// 模拟进程崩溃时只写了一半的记录
+	file, err := os.OpenFile(options.AofFile, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 1})
+	file.Close()
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.aof.close()
+
+	if _, ok := recovered.Get("0"); ok {
+		t.Fatal("0 should be deleted")
+	}
+
+	if value, ok := recovered.Get("1"); !ok || string(value) != "one" {
+		t.Fatalf("value of 1 is wrong, got %s", value)
+	}
+
+	if status := recovered.Status(); status.Count != 99 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+}
+
+// go test -v -run=^TestAofReplayCorruptedTail$
+func TestAofReplayCorruptedTail(t *testing.T) {
+
+	tails := [][]byte{
+		// 长度接近 4 GB，但是后面只有几个字节，不能按照这个长度分配内存
+		append([]byte{0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0}, []byte("torn")...),
+		// 长度是完整的，但是校验和对不上
+		append([]byte{0, 0, 0, 4, 0, 0, 0, 0}, []byte("torn")...),
+	}
+
+	for i, tail := range tails {
+		options, cleanup := newDumpTestOptions(t)
+		options.AofFile = filepath.Join(filepath.Dir(options.DumpFile), "kafo.aof")
+		options.AofFsync = AofFsyncAlways
+		cache, err := NewCacheWith(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cache.Set("key", []byte("value"))
+		cache.aof.close()
+
+		info, err := os.Stat(options.AofFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.OpenFile(options.AofFile, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(tail)
+		file.Close()
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		recovered, err := NewCacheWith(options)
+		if err != nil {
+			t.Fatalf("tail %d: %+v", i, err)
+		}
+		recovered.aof.close()
+
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+			t.Fatalf("tail %d: replaying the aof file allocated %d bytes", i, allocated)
+		}
+
+		if value, ok := recovered.Get("key"); !ok || string(value) != "value" {
+			t.Fatalf("tail %d: value of key is wrong, got %s", i, value)
+		}
+
+		// 损坏的记录需要被截断，之后的写入才能接在完整的记录后面
+		if truncated, err := os.Stat(options.AofFile); err != nil || truncated.Size() != info.Size() {
+			t.Fatalf("tail %d: aof file should be truncated to %d bytes", i, info.Size())
+		}
+		cleanup()
+	}
+}
+
+// go test -v -run=^TestRestoreAndFlush$
+func TestRestoreAndFlush(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	options.AofFile = filepath.Join(filepath.Dir(options.DumpFile), "kafo.aof")
+	options.AofFsync = AofFsyncAlways
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key", []byte("dumped"))
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshotFile := options.DumpFile + ".20060102150405000"
+	if err = copyFile(options.DumpFile, snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key", []byte("changed"))
+	cache.Set("other", []byte("other"))
+	if err = cache.Restore(filepath.Base(snapshotFile)); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "dumped" {
+		t.Fatalf("value of key %s should be restored", value)
+	}
+
+	if _, ok := cache.Get("other"); ok {
+		t.Fatal("other should be removed by restore")
+	}
+
+	if err = cache.Restore("20060102150405001"); err == nil {
+		t.Fatal("restoring from a missing file should return an error")
+	}
+
+	// 只能使用持久化文件旁边的历史快照，其他的文件都不能读取
+	for _, snapshot := range []string{snapshotFile, "../" + filepath.Base(snapshotFile), "kafo.aof", "..", ""} {
+		if err = cache.Restore(snapshot); err != InvalidSnapshotErr {
+			t.Fatalf("restoring from %s should return InvalidSnapshotErr, but got %v", snapshot, err)
+		}
+	}
+
+	// 快照的时间戳也可以直接使用，上一次恢复之后的持久化会按照保留个数清理掉这个快照，所以需要重新复制一份
+	if err = copyFile(options.DumpFile, snapshotFile); err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key", []byte("changed"))
+	if err = cache.Restore("20060102150405000"); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "dumped" {
+		t.Fatalf("value of key %s should be restored", value)
+	}
+
+	// 清空操作会记录到 AOF 中，重启之后也不会恢复清空之前的数据
+	cache.Set("other", []byte("other"))
+	if err = cache.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("flushed", []byte("flushed"))
+	cache.aof.close()
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.aof.close()
+
+	if status := recovered.Status(); status.Count != 1 {
+		t.Fatalf("count %d should be 1 after flush", status.Count)
+	}
+
+	if _, ok := recovered.Get("flushed"); !ok {
+		t.Fatal("flushed should be set after flush")
+	}
+}
+
+// go test -v -run=^TestReplaySecondsAof$
+func TestReplaySecondsAof(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	// 模拟 ttl 单位还是秒的旧版本 AOF 文件
+	options.AofFile = filepath.Join(filepath.Dir(options.DumpFile), "kafo.aof")
+	record, err := encodeAofRecord(&aofRecord{
+		Op:    aofSetOp,
+		Key:   "key",
+		Value: &value{Data: []byte("value"), Ttl: 3600, Ctime: time.Now().Unix()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := append([]byte(aofMagic), secondsAofVersion)
+	if err = ioutil.WriteFile(options.AofFile, append(data, record...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("other", []byte("other"))
+	cache.aof.close()
+
+	// 旧版本的 AOF 文件在启动的时候就被持久化并替换成了新版本的文件
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.aof.close()
+
+	for _, key := range []string{"key", "other"} {
+		if _, ok := recovered.Get(key); !ok {
+			t.Fatalf("%s should be recovered", key)
+		}
+	}
+}
@@ -1,14 +1,36 @@
 package caches
 
 import (
+	"context"
+	"errors"
+	"os"
+	"path"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
+var (
+	// RestoreTooLargeErr 是持久化文件中的数据超过了缓存的容量上限，没办法全部恢复时返回的错误。
+	RestoreTooLargeErr = errors.New("the dump file has more data than the cache can hold")
+)
+
 // Cache 是代表缓存的结构体。
+// 注意下面的计数器都是使用 atomic 包进行原子更新的，所以要放在结构体的最前面，保证在 32 位平台上也是 64 位对齐的。
 type Cache struct {
 
+	// gcRuns 记录着 gc 执行的次数。
+	gcRuns int64
+
+	// gcTime 记录着 gc 累计消耗的时间，单位是纳秒。
+	gcTime int64
+
+	// dumpRuns 记录着持久化执行的次数。
+	dumpRuns int64
+
+	// dumpTime 记录着持久化累计消耗的时间，单位是纳秒。
+	dumpTime int64
+
 	// segmentSize 是 segment 的数量，这个数量越多，理论上并发的性能就越好。
 	segmentSize int
 
@@ -18,41 +40,197 @@ type Cache struct {
 	// options 是缓存配置。
 	options *Options
 
-	// dumping 标识当前缓存是否处于持久化状态。1 表示处于持久化状态。
-    // 因为现在的 cache 是没有全局锁的，而持久化需要记录下当前的状态，不允许有更新，所以使用一个变量记录着，
-    // 如果处于持久化状态，就让所有更新操作进入自旋状态，等待持久化完成再进行。
-	dumping int32
+	// dumpLock 用于保证同一时间只有一个持久化任务在执行。
+	// 持久化是逐个 segment 做快照的，不会阻塞读写操作，所以这个锁只和持久化任务有关。
+	dumpLock *sync.Mutex
+
+	// aof 是记录写操作的日志文件，没有开启 AOF 的时候为空。
+	aof *appendOnlyFile
+
+	// aofRewriteSize 是触发 AOF 重写的文件大小。
+	aofRewriteSize int64
+
+	// closeCh 用于通知后台任务退出，缓存关闭的时候会被关闭。
+	closeCh chan struct{}
+
+	// closeOnce 用于保证缓存只会被关闭一次。
+	closeOnce *sync.Once
+
+	// dropped 表示这个命名空间已经被删除了，之后的持久化都不会再执行，需要持有 dumpLock 才能访问。
+	dropped bool
+
+	// namespaces 存储着所有的命名空间，只有默认命名空间才会有，命名空间自己的这个字段为空。
+	namespaces map[string]*Cache
+
+	// namespaceOptions 存储着所有命名空间的选项配置，会被记录到文件中用于重启之后恢复命名空间。
+	namespaceOptions map[string]NamespaceOptions
+
+	// namespaceLock 用于保护 namespaces 和 namespaceOptions。
+	namespaceLock *sync.RWMutex
 }
 
 // NewCache 返回一个默认配置的缓存实例。
-func NewCache() *Cache {
+func NewCache() (*Cache, error) {
 	return NewCacheWith(DefaultOptions())
 }
 
-// NewCacheWith 返回一个使用 options 初始化过的缓存实例
-func NewCacheWith(options Options) *Cache {
-    // 尝试从持久化文件中恢复
-	if cache, ok := recoverFromDumpFile(options.DumpFile); ok {
-		return cache
+// NewCacheWith 返回一个使用 options 初始化过的缓存实例，之前创建过的命名空间也会一起恢复。
+func NewCacheWith(options Options) (*Cache, error) {
+	cache, err := newCacheWith(options)
+	if err != nil {
+		return nil, err
+	}
+
+	cache.namespaces = map[string]*Cache{}
+	cache.namespaceOptions = map[string]NamespaceOptions{}
+	cache.namespaceLock = &sync.RWMutex{}
+	if err = cache.loadNamespaces(); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// newCacheWith 返回一个使用 options 初始化过的缓存实例，不包含命名空间，命名空间也是使用这个方法创建的。
+func newCacheWith(options Options) (*Cache, error) {
+	if err := checkDumpCompression(options.DumpCompression); err != nil {
+		return nil, err
+	}
+
+	if err := checkEvictionPolicy(options.EvictionPolicy); err != nil {
+		return nil, err
 	}
-	return &Cache{
+
+	cache := &Cache{
 		segmentSize: options.SegmentSize,
-        
-        // 初始化所有的 segment
-		segments:    newSegments(&options),
-		options:     &options,
-		dumping:     0,
+
+		// 初始化所有的 segment
+		segments:  newSegments(&options),
+		options:   &options,
+		dumpLock:  &sync.Mutex{},
+		closeCh:   make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
+
+	// 指定了历史快照的话就从历史快照恢复，否则从最新的持久化文件恢复
+	recoverFile := options.DumpFile
+	if options.RecoverSnapshot != "" {
+		snapshotFile, err := snapshotFileOf(options.DumpFile, options.RecoverSnapshot)
+		if err != nil {
+			return nil, err
+		}
+		recoverFile = snapshotFile
+	}
+
+    // 尝试从持久化文件中恢复，持久化文件损坏的时候直接返回错误，而不是悄悄地使用一个空的缓存
+	if err := cache.recoverFromDumpFile(recoverFile); err != nil {
+		return nil, err
+	}
+
+	// 开启了 AOF 的话，需要在持久化文件的基础上回放 AOF 中记录的写操作
+	if options.AofFile != "" {
+		if err := cache.openAof(options); err != nil {
+			return nil, err
+		}
+	}
+
+	// 从历史快照恢复之后马上持久化一次，让它成为最新的持久化文件，否则下次启动的时候又会回到原来的数据
+	if options.RecoverSnapshot != "" {
+		if err := cache.dump(); err != nil {
+			return nil, err
+		}
+	}
+	return cache, nil
+}
+
+// recoverFromDumpFile 从持久化文件中恢复缓存数据。
+// 数据会按照当前的选项配置重新分配到 segment 中，所以修改了 segment 数量或者容量上限之后也可以正常恢复。
+// 如果持久化文件不存在就什么也不做，如果持久化文件损坏了就返回错误。
+func (c *Cache) recoverFromDumpFile(dumpFile string) error {
+	err := loadDumpFile(dumpFile, c.restore)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// restore 把持久化文件中的一条数据恢复到缓存中。
+// 已经过期的数据会被直接丢弃，超过容量上限的数据会按照淘汰策略处理，淘汰策略不允许淘汰的话就丢弃这条数据。
+func (c *Cache) restore(key string, v *value) {
+	if !v.alive() {
+		return
+	}
+
+	segment := c.segmentOf(key)
+	segment.lock.Lock()
+	defer segment.lock.Unlock()
+	segment.setValue(key, v)
 }
 
-// recoverFromDumpFile 从持久化文件中恢复缓存。
-// 如果恢复失败就返回 false。
-func recoverFromDumpFile(dumpFile string) (*Cache, bool) {
-	cache, err := newEmptyDump().from(dumpFile)
+// openAof 回放 AOF 文件中记录的写操作，然后打开 AOF 文件继续记录之后的写操作。
+// 上一次重写失败留下的旧 AOF 文件中的操作比当前 AOF 文件中的早，所以要先回放。
+// 从历史快照恢复的时候，AOF 中的操作都发生在快照之后，所以不会回放，而是把 AOF 文件重命名保留下来。
+func (c *Cache) openAof(options Options) error {
+	aofFiles := []string{options.AofFile + rewritingAofSuffix, options.AofFile}
+	upgrading := false
+	for _, aofFile := range aofFiles {
+		if options.RecoverSnapshot != "" {
+			if err := discardAofFile(aofFile); err != nil {
+				return err
+			}
+			continue
+		}
+
+		version, err := replayAofFile(aofFile, c.replay)
+		if err != nil {
+			return err
+		}
+		upgrading = upgrading || (version > 0 && version < aofVersion)
+	}
+
+	// 旧版本的 AOF 文件不能继续追加新版本的记录，所以先持久化一次，再删除旧版本的 AOF 文件
+	if upgrading {
+		if err := c.Dump(); err != nil {
+			return err
+		}
+
+		for _, aofFile := range aofFiles {
+			if err := os.Remove(aofFile); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	aof, err := openAppendOnlyFile(options.AofFile, options.AofFsync)
 	if err != nil {
-		return nil, false
+		return err
 	}
-	return cache, true
+
+	c.aof = aof
+	c.aofRewriteSize = options.AofRewriteSize
+	for _, segment := range c.segments {
+		segment.aof = aof
+	}
+	return nil
+}
+
+// replay 回放一条 AOF 记录，已经过期的数据会被直接丢弃。
+func (c *Cache) replay(record *aofRecord) {
+	if record.Op == aofFlushOp {
+		c.lockAll()
+		defer c.unlockAll()
+		c.flushSegments()
+		return
+	}
+
+	if record.Op == aofSetOp && record.Value != nil && record.Value.alive() {
+		c.restore(record.Key, record.Value)
+		return
+	}
+
+	segment := c.segmentOf(record.Key)
+	segment.lock.Lock()
+	defer segment.lock.Unlock()
+	segment.removeEntry(record.Key)
 }
 
 // newSegments 返回初始化好的 segment 实例列表。
@@ -85,8 +263,12 @@ func (c *Cache) segmentOf(key string) *segment {
 
 // Get 返回指定 key 的数据。
 func (c *Cache) Get(key string) ([]byte, bool) {
-    // 这边会等待持久化完成
-	c.waitForDumping()
+	value, _, ok := c.segmentOf(key).get(key)
+	return value, ok
+}
+
+// GetWithVersion 返回指定 key 的数据和它的版本号，版本号可以用于 CompareAndSet。
+func (c *Cache) GetWithVersion(key string) ([]byte, uint64, bool) {
 	return c.segmentOf(key).get(key)
 }
 
@@ -95,19 +277,289 @@ func (c *Cache) Set(key string, value []byte) error {
 	return c.SetWithTTL(key, value, NeverDie)
 }
 
-// SetWithTTL 添加指定的数据到缓存中，并设置相应的有效期。
-func (c *Cache) SetWithTTL(key string, value []byte, ttl int64) error {
-    // 这边会等待持久化完成
-	c.waitForDumping()
-	return c.segmentOf(key).set(key, value, ttl)
+// SetWithTTL 添加指定的数据到缓存中，并设置相应的有效期，有效期可以精确到纳秒，NeverDie 表示使用默认有效期，默认是永不过期。
+// 设置了默认有效期还需要添加永不过期的数据的话，可以使用 Forever 作为有效期。
+// 默认从创建开始计算有效期，使用 Sliding 的话会从最后一次访问开始计算，还可以使用 WithMaxAge 限制最长寿命。
+func (c *Cache) SetWithTTL(key string, value []byte, ttl time.Duration, opts ...SetOption) error {
+	return c.segmentOf(key).set(key, c.newValue(value, ttl, opts))
+}
+
+// newValue 使用 ttl 和 opts 创建数据，没有指定有效期的话使用缓存配置的默认有效期，Forever 表示永不过期。
+func (c *Cache) newValue(data []byte, ttl time.Duration, opts []SetOption) *value {
+	switch ttl {
+	case Forever:
+		ttl = NeverDie
+	case NeverDie:
+		ttl = c.options.DefaultTTL
+	}
+	return newValueWith(data, ttl, NewSetOptions(opts...))
+}
+
+// valueToModify 返回修改 valueType 类型的数据时使用的副本，oldValue 是数据当前的值，为空的话使用 ttl 和 opts 创建一个新的数据。
+// 这样只有创建的时候才会设置有效期，已经存在的数据会保留原来的有效期，oldValue 是其他类型的话返回 WrongTypeErr。
+func (c *Cache) valueToModify(oldValue *value, valueType byte, ttl time.Duration, opts []SetOption) (*value, error) {
+	if oldValue == nil {
+		newValue := c.newValue(nil, ttl, opts)
+		newValue.Type = valueType
+		return newValue, nil
+	}
+
+	if oldValue.Type != valueType {
+		return nil, WrongTypeErr
+	}
+	return oldValue.copyForUpdate(), nil
+}
+
+// SetWithExpireAt 添加指定的数据到缓存中，并在 expireAt 这个时间点过期。
+// 如果 expireAt 已经过去了，这个数据一添加就已经过期了，所以相当于删除这个 key。
+func (c *Cache) SetWithExpireAt(key string, value []byte, expireAt time.Time) error {
+	ttl := time.Until(expireAt)
+	if ttl <= 0 {
+		return c.Delete(key)
+	}
+	return c.SetWithTTL(key, value, ttl)
+}
+
+// CompareAndSet 只有在指定 key 的数据的版本号等于 expectedVersion 的时候才添加数据，返回新数据的版本号。
+// 版本号不一致或者数据不存在的话返回 VersionMismatchErr，说明数据在这期间被别人修改过了，需要重新获取之后再试。
+func (c *Cache) CompareAndSet(key string, value []byte, expectedVersion uint64, ttl time.Duration, opts ...SetOption) (uint64, error) {
+	return c.segmentOf(key).setIf(key, c.newValue(value, ttl, opts), versionIs(expectedVersion))
+}
+
+// SetIfAbsent 只有在指定 key 的数据不存在的时候才添加数据，返回新数据的版本号，数据已经存在的话返回 KeyExistsErr。
+func (c *Cache) SetIfAbsent(key string, value []byte, ttl time.Duration, opts ...SetOption) (uint64, error) {
+	return c.segmentOf(key).setIf(key, c.newValue(value, ttl, opts), absent)
+}
+
+// SetIfPresent 只有在指定 key 的数据存在的时候才添加数据，返回新数据的版本号，数据不存在的话返回 KeyNotFoundErr。
+func (c *Cache) SetIfPresent(key string, value []byte, ttl time.Duration, opts ...SetOption) (uint64, error) {
+	return c.segmentOf(key).setIf(key, c.newValue(value, ttl, opts), present)
+}
+
+// versionIs 返回检查数据版本号是否等于 expectedVersion 的函数，数据不存在也算不相等。
+func versionIs(expectedVersion uint64) func(oldValue *value) error {
+	return func(oldValue *value) error {
+		if oldValue == nil || oldValue.Version != expectedVersion {
+			return VersionMismatchErr
+		}
+		return nil
+	}
+}
+
+// absent 检查数据是否不存在。
+func absent(oldValue *value) error {
+	if oldValue != nil {
+		return KeyExistsErr
+	}
+	return nil
+}
+
+// present 检查数据是否存在。
+func present(oldValue *value) error {
+	if oldValue == nil {
+		return KeyNotFoundErr
+	}
+	return nil
+}
+
+// TTL 返回指定 key 的数据还剩多长时间过期，永不过期的数据返回 NeverDie，第二个返回值表示 key 是否存在。
+func (c *Cache) TTL(key string) (time.Duration, bool) {
+	return c.segmentOf(key).ttl(key)
+}
+
+// Expire 把指定 key 的数据设置为从现在开始 ttl 之后过期，返回 key 是否存在。
+// 空闲过期的配置会保留下来，所以空闲过期的数据相当于设置了最长寿命，ttl 小于等于 0 的话会马上删除这个数据。
+func (c *Cache) Expire(key string, ttl time.Duration) (bool, error) {
+	ok, err := c.segmentOf(key).update(key, func(v *value) {
+		v.Ttl = time.Now().UnixNano() + int64(ttl) - v.Ctime
+	})
+
+	if ok && err == nil && ttl <= 0 {
+		err = c.Delete(key)
+	}
+	return ok, err
+}
+
+// Persist 移除指定 key 的数据的所有过期设置，让它永不过期，返回 key 是否存在。
+func (c *Cache) Persist(key string) (bool, error) {
+	return c.segmentOf(key).update(key, func(v *value) {
+		v.Ttl = NeverDie
+		v.Idle = NeverDie
+	})
+}
+
+// Touch 更新指定 key 的数据的访问时间，但是不读取数据，返回 key 是否存在。
+// 空闲过期的数据会重新计算空闲时间，同时淘汰策略也会把它当作刚刚访问过的数据。
+func (c *Cache) Touch(key string) (bool, error) {
+	return c.segmentOf(key).update(key, func(v *value) {
+		v.Atime = time.Now().UnixNano()
+	})
+}
+
+// Incr 把指定 key 的整数加 1 并返回加完之后的结果，key 不存在的话会创建一个值为 1 的数据。
+// ttl 和 opts 只在创建数据的时候使用，已经存在的数据会保留原来的有效期。
+func (c *Cache) Incr(key string, ttl time.Duration, opts ...SetOption) (int64, error) {
+	return c.IncrBy(key, 1, ttl, opts...)
+}
+
+// Decr 把指定 key 的整数减 1 并返回减完之后的结果，key 不存在的话会创建一个值为 -1 的数据。
+// ttl 和 opts 只在创建数据的时候使用，已经存在的数据会保留原来的有效期。
+func (c *Cache) Decr(key string, ttl time.Duration, opts ...SetOption) (int64, error) {
+	return c.IncrBy(key, -1, ttl, opts...)
+}
+
+// IncrBy 把指定 key 的整数加上 delta 并返回加完之后的结果，delta 是负数的话就是减法。
+// 整数使用十进制的字符串存储，所以可以直接使用 Get 和 Set 读写，数据不是整数的话返回 NotIntegerErr。
+// key 不存在的话会创建一个值为 delta 的数据，ttl 和 opts 只在创建数据的时候使用，已经存在的数据会保留原来的有效期。
+func (c *Cache) IncrBy(key string, delta int64, ttl time.Duration, opts ...SetOption) (int64, error) {
+	return c.segmentOf(key).incrBy(key, delta, func(data []byte) *value {
+		return c.newValue(data, ttl, opts)
+	})
 }
 
 // Delete 从缓存中删除指定 key 的数据。
 func (c *Cache) Delete(key string) error {
-    // 这边会等待持久化完成
-	c.waitForDumping()
-	c.segmentOf(key).delete(key)
-	return nil // 原本这个方法是没有返回值的，现在需要加一个 error 的返回值
+	return c.segmentOf(key).delete(key)
+}
+
+// Flush 清空缓存中的所有数据。
+// 清空的时候会给所有的 segment 加锁，保证 AOF 中清空操作之后的记录都是清空之后才发生的。
+func (c *Cache) Flush() error {
+	c.lockAll()
+	defer c.unlockAll()
+	if c.aof != nil {
+		if err := c.aof.append(aofFlushOp, "", nil); err != nil {
+			return err
+		}
+	}
+	c.flushSegments()
+	return nil
+}
+
+// flushSegments 清空所有 segment 的数据，调用之前需要先给所有的 segment 加锁。
+func (c *Cache) flushSegments() {
+	for _, segment := range c.segments {
+		segment.replaceWith(newSegment(c.options))
+	}
+}
+
+// Restore 使用 snapshot 对应的历史快照替换掉缓存中的所有数据，snapshot 是快照文件名中的时间戳或者快照的文件名。
+// 只能使用持久化文件所在目录中的历史快照，其他的路径都会返回 InvalidSnapshotErr。
+// 数据会先加载到新的 segment 中，加载成功之后才会替换，所以持久化文件损坏或者数据超过容量上限的时候现有的数据不受影响。
+// 替换的时候会先清空缓存，再写入加载好的数据，这些操作都会记录到 AOF 中，最后再持久化一次，保证重启之后恢复的也是替换之后的数据。
+func (c *Cache) Restore(snapshot string) error {
+	restoreFile, err := restoreFileOf(c.options.DumpFile, snapshot)
+	if err != nil {
+		return err
+	}
+
+	restored := &Cache{
+		segmentSize: c.segmentSize,
+		segments:    newSegments(c.options),
+		options:     c.options,
+	}
+
+	if err = loadDumpFile(restoreFile, restored.restore); err != nil {
+		return err
+	}
+
+	// 加载的时候容量不够就会淘汰或者丢弃一部分数据，这时候替换的话现有的数据就会被一份不完整的数据覆盖掉
+	if status := restored.Status(); status.Evicted > 0 || status.Rejected > 0 {
+		return RestoreTooLargeErr
+	}
+
+	if err = c.replaceWith(restored); err != nil {
+		return err
+	}
+	return c.Dump()
+}
+
+// replaceWith 使用 other 的数据替换掉缓存中的所有数据，other 的 segment 数量和选项配置需要和当前缓存一致。
+func (c *Cache) replaceWith(other *Cache) error {
+	c.lockAll()
+	defer c.unlockAll()
+	if c.aof != nil {
+		if err := c.aof.append(aofFlushOp, "", nil); err != nil {
+			return err
+		}
+	}
+
+	c.flushSegments()
+	for i, segment := range other.segments {
+		for key, value := range segment.Data {
+			if err := c.segments[i].setValue(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// lockAll 按照顺序给所有的 segment 加写锁，用于需要整体修改缓存的操作。
+func (c *Cache) lockAll() {
+	for _, segment := range c.segments {
+		segment.lock.Lock()
+	}
+}
+
+// unlockAll 释放所有 segment 的写锁。
+func (c *Cache) unlockAll() {
+	for _, segment := range c.segments {
+		segment.lock.Unlock()
+	}
+}
+
+// DeleteByPrefix 删除缓存中所有以 prefix 开头的数据，返回删除的数量。
+// 每次只会锁住一个 segment，所以删除期间添加的数据可能会被删除也可能不会。
+func (c *Cache) DeleteByPrefix(prefix string) (int, error) {
+	return c.deleteFromSegments(func(segment *segment) (int, error) {
+		return segment.deleteByPrefix(prefix)
+	})
+}
+
+// DeleteByTag 删除缓存中所有带有 tag 这个标签的数据，返回删除的数量，标签是添加数据的时候使用 WithTags 设置的。
+// 每个 segment 都有标签索引，所以只会访问带有这个标签的数据，不需要遍历所有的数据。
+func (c *Cache) DeleteByTag(tag string) (int, error) {
+	return c.deleteFromSegments(func(segment *segment) (int, error) {
+		return segment.deleteByTag(tag)
+	})
+}
+
+// deleteFromSegments 在每个 segment 上执行 deleteFrom，返回删除的总数，遇到错误就马上返回。
+func (c *Cache) deleteFromSegments(deleteFrom func(segment *segment) (int, error)) (int, error) {
+	total := 0
+	for _, segment := range c.segments {
+		deleted, err := deleteFrom(segment)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Scan 从 cursor 开始遍历缓存中匹配 pattern 的 key，返回遍历到的 key 和下一次遍历使用的 cursor，返回的 cursor 为 0 表示遍历完了。
+// 第一次遍历的时候 cursor 传 0，pattern 使用 path.Match 的语法，比如 user:*，注意 * 不会匹配 /，空的 pattern 匹配所有的 key。
+// 遍历是一个 segment 一个 segment 进行的，每次只会锁住正在遍历的 segment，所以 count 只是一个参考值，返回的 key 可能会多于 count。
+// 遍历期间一直存在的 key 一定会被返回并且只返回一次，遍历期间添加或者删除的 key 可能返回也可能不返回。
+func (c *Cache) Scan(cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, 0, err
+	}
+
+	var keys []string
+	for cursor < uint64(len(c.segments)) {
+		keys = c.segments[cursor].scan(pattern, keys)
+		cursor++
+		if len(keys) >= count {
+			break
+		}
+	}
+
+	if cursor >= uint64(len(c.segments)) {
+		cursor = 0
+	}
+	return keys, cursor, nil
 }
 
 // Status 返回缓存当前的情况。
@@ -115,66 +567,189 @@ func (c *Cache) Status() Status {
 	result := NewStatus() // 修改为导出的方法
 	for _, segment := range c.segments {
 		status := segment.status()
-		result.Count += status.Count
-		result.KeySize += status.KeySize
-		result.ValueSize += status.ValueSize
+		result.Merge(&status)
 	}
+	result.GcRuns = atomic.LoadInt64(&c.gcRuns)
+	result.GcTime = atomic.LoadInt64(&c.gcTime)
+	result.DumpRuns = atomic.LoadInt64(&c.dumpRuns)
+	result.DumpTime = atomic.LoadInt64(&c.dumpTime)
 	return *result
 }
 
 // gc 会清理缓存中过期的数据。
+// 每个 segment 都有按照过期时间排序的过期队列，所以只需要处理已经到期的数据，不需要遍历所有的数据。
+// 每个 segment 每次加锁只会处理一小批数据，处理完一批之后会释放锁，让读写操作有机会执行。
 func (c *Cache) gc() {
-    // 这边会等待持久化完成
-	c.waitForDumping()
-	wg := &sync.WaitGroup{}
-	for _, seg := range c.segments {
-		wg.Add(1)
-		go func(s *segment) {
-			defer wg.Done()
-			s.gc()
-		}(seg)
+	beginTime := time.Now()
+	defer c.recordTask(&c.gcRuns, &c.gcTime, beginTime)
+	now := beginTime.UnixNano()
+	for _, segment := range c.segments {
+		for segment.gc(now) {
+		}
+	}
+
+	// 命名空间没有自己的后台任务，由默认命名空间统一清理
+	c.eachNamespace(func(namespace *Cache) error {
+		namespace.gc()
+		return nil
+	})
+}
+
+// expire 主动清理缓存中已经到期的数据，每个 segment 只处理一批，剩下的留给下一次主动过期或者 gc。
+// 这样每次执行的时间是有上限的，可以频繁地执行，让过期的数据尽快释放内存。
+func (c *Cache) expire() {
+	now := time.Now().UnixNano()
+	for _, segment := range c.segments {
+		segment.gc(now)
 	}
-	wg.Wait()
+
+	c.eachNamespace(func(namespace *Cache) error {
+		namespace.expire()
+		return nil
+	})
 }
 
-// AutoGc 会开启一个异步任务去定时清理过期的数据。
+// AutoGc 会开启一个异步任务去定时清理过期的数据，缓存关闭之后这个任务就会退出。
+// 每隔 GcDuration 分钟清理掉所有过期的数据，配置了 ExpiryInterval 的话还会每隔 ExpiryInterval 毫秒主动过期一批数据。
 func (c *Cache) AutoGc() {
 	go func() {
 		ticker := time.NewTicker(time.Duration(c.options.GcDuration) * time.Minute)
+		defer ticker.Stop()
+
+		// 没有开启主动过期的话，这个 channel 一直为空，select 永远不会选中它
+		var expiryCh <-chan time.Time
+		if c.options.ExpiryInterval > 0 {
+			expiryTicker := time.NewTicker(time.Duration(c.options.ExpiryInterval) * time.Millisecond)
+			defer expiryTicker.Stop()
+			expiryCh = expiryTicker.C
+		}
+
 		for {
 			select {
 			case <-ticker.C:
 				c.gc()
+			case <-expiryCh:
+				c.expire()
+			case <-c.closeCh:
+				return
 			}
 		}
 	}()
 }
 
-// dump 会将缓存数据持久化到文件中。
+// Dump 会将缓存数据持久化到文件中。
+// 持久化的时候会逐个 segment 复制出快照，每次只会短暂地持有一个 segment 的读锁，
+// 所以读操作不会被阻塞，写操作最多也只会等待一个 segment 复制完成，之后的编码和写文件都是在快照上进行的。
+// 开启了 AOF 的话，持久化之前会先切换 AOF 文件，持久化成功之后旧的 AOF 文件就可以删掉了，这也就是 AOF 的重写。
+// 切换之后、快照之前的写操作会同时存在于快照和新的 AOF 文件中，但是 AOF 记录的都是操作之后的完整数据，重复回放也没有问题。
+// 所有的命名空间也会持久化到各自的文件中。
+func (c *Cache) Dump() error {
+	if err := c.dump(); err != nil {
+		return err
+	}
+	return c.eachNamespace(func(namespace *Cache) error {
+		return namespace.dump()
+	})
+}
+
+// dump 只持久化当前命名空间的数据，已经被删除的命名空间不会再持久化。
 func (c *Cache) dump() error {
-    // 这边使用 atomic 包中的原子操作完成状态的切换
-	atomic.StoreInt32(&c.dumping, 1)
-	defer atomic.StoreInt32(&c.dumping, 0)
-	return newDump(c).to(c.options.DumpFile)
+	c.dumpLock.Lock()
+	defer c.dumpLock.Unlock()
+	if c.dropped {
+		return nil
+	}
+
+	defer c.recordTask(&c.dumpRuns, &c.dumpTime, time.Now())
+	if c.aof != nil {
+		if err := c.aof.rotate(); err != nil {
+			return err
+		}
+	}
+
+	if err := newDump(c).to(c.options.DumpFile, c.options.DumpRetention); err != nil {
+		return err
+	}
+
+	if c.aof != nil {
+		return c.aof.finishRewrite()
+	}
+	return nil
 }
 
-// AutoDump 会开启一个异步任务去定时持久化缓存数据。
+// recordTask 记录一次后台任务的执行次数和消耗时间。
+func (c *Cache) recordTask(runs *int64, totalTime *int64, beginTime time.Time) {
+	atomic.AddInt64(runs, 1)
+	atomic.AddInt64(totalTime, int64(time.Since(beginTime)))
+}
+
+// AutoDump 会开启一个异步任务去定时持久化缓存数据，缓存关闭之后这个任务就会退出。
+// 开启了 AOF 的话，AOF 文件超过了 aofRewriteSize 也会马上持久化，从而重写 AOF。
 func (c *Cache) AutoDump() {
 	go func() {
 		ticker := time.NewTicker(time.Duration(c.options.DumpDuration) * time.Minute)
+		aofTicker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		defer aofTicker.Stop()
 		for {
 			select {
 			case <-ticker.C:
-				c.dump()
+				c.Dump()
+			case <-aofTicker.C:
+				c.rewriteAofIfNeeded()
+				c.eachNamespace(func(namespace *Cache) error {
+					namespace.rewriteAofIfNeeded()
+					return nil
+				})
+			case <-c.closeCh:
+				return
 			}
 		}
 	}()
 }
 
-// waitForDumping 会等待持久化完成才返回。
-func (c *Cache) waitForDumping() {
-	for atomic.LoadInt32(&c.dumping) != 0 {
-        // 每次循环都会等待一定的时间，如果不睡眠，会导致 CPU 空转消耗资源
-		time.Sleep(time.Duration(c.options.CasSleepTime) * time.Microsecond)
+// rewriteAofIfNeeded 在 AOF 文件超过了 aofRewriteSize 的时候持久化一次，从而重写 AOF。
+func (c *Cache) rewriteAofIfNeeded() {
+	if c.aof != nil && c.aofRewriteSize > 0 && c.aof.fileSize() >= c.aofRewriteSize {
+		c.dump()
 	}
 }
+
+// Close 关闭缓存，会停止所有的后台任务，然后做最后一次持久化并关闭 AOF 文件。
+// 最后一次持久化超过了 ctx 的期限就不再等待，直接返回 ctx 的错误，这种情况下 AOF 中的数据仍然会刷盘，重启的时候还可以通过 AOF 恢复。
+// 持久化的时候可能会切换或者重写 AOF，所以 AOF 由持久化的 goroutine 在持久化结束之后关闭，超时返回之后也不会和持久化冲突。
+// 所有的命名空间也会一起关闭，重复调用 Close 不会有任何效果。
+func (c *Cache) Close(ctx context.Context) (err error) {
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+
+		dumpCh := make(chan error, 1)
+		go func() {
+			var dumpErr error
+			if c.options.DumpFile != "" {
+				dumpErr = c.dump()
+			}
+
+			if c.aof != nil {
+				if closeErr := c.aof.close(); dumpErr == nil {
+					dumpErr = closeErr
+				}
+			}
+			dumpCh <- dumpErr
+		}()
+
+		select {
+		case err = <-dumpCh:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		closeErr := c.eachNamespace(func(namespace *Cache) error {
+			return namespace.Close(ctx)
+		})
+		if err == nil {
+			err = closeErr
+		}
+	})
+	return err
+}
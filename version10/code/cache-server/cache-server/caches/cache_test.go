@@ -1,6 +1,9 @@
 package caches
 
 import (
+	"context"
+	"math"
+	"os"
 	"strconv"
 	"sync"
 	"testing"
@@ -31,7 +34,10 @@ func testTask(task func(no int)) string {
 // go test -v -run=^TestCacheSetGet$
 func TestCacheSetGet(t *testing.T) {
 
-	cache := NewCache()
+	cache, err := NewCache()
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	writeTime := testTask(func(no int) {
 		data := strconv.Itoa(no)
@@ -49,3 +55,829 @@ func TestCacheSetGet(t *testing.T) {
 
 	t.Logf("读取消耗时间为 %s！", readTime)
 }
+
+// go test -v -run=^TestCacheStatus$
+func TestCacheStatus(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("key", []byte("value"))
+	cache.Get("key")
+	cache.Get("missing")
+	cache.Delete("key")
+
+	status := cache.Status()
+	if status.Gets != 2 || status.Hits != 1 || status.Misses != 1 {
+		t.Fatalf("gets %d, hits %d and misses %d are wrong", status.Gets, status.Hits, status.Misses)
+	}
+
+	if status.Sets != 1 || status.Deletes != 1 || status.Count != 0 || status.MemorySize != 0 {
+		t.Fatalf("status %+v is wrong", status)
+	}
+}
+
+// go test -v -run=^TestCacheMillisecondTTL$
+func TestCacheMillisecondTTL(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetWithTTL("ttl", []byte("ttl"), 50*time.Millisecond)
+	cache.SetWithExpireAt("expireAt", []byte("expireAt"), time.Now().Add(50*time.Millisecond))
+	cache.Set("expired", []byte("expired"))
+	cache.SetWithExpireAt("expired", []byte("expired"), time.Now().Add(-time.Millisecond))
+
+	if _, ok := cache.Get("expired"); ok {
+		t.Fatal("setting with a past expire time should remove the key")
+	}
+
+	if _, ok := cache.Get("ttl"); !ok {
+		t.Fatal("ttl should not expire yet")
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	for _, key := range []string{"ttl", "expireAt"} {
+		if _, ok := cache.Get(key); ok {
+			t.Fatalf("%s should expire after 100ms", key)
+		}
+	}
+}
+
+// go test -v -run=^TestCacheExpirationModes$
+func TestCacheExpirationModes(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.SetWithTTL("absolute", []byte("absolute"), 100*time.Millisecond)
+	cache.SetWithTTL("sliding", []byte("sliding"), 100*time.Millisecond, Sliding())
+	cache.SetWithTTL("maxAge", []byte("maxAge"), 100*time.Millisecond, Sliding(), WithMaxAge(150*time.Millisecond))
+
+	// 每隔 60ms 访问一次，空闲过期的数据会一直续期，而绝对过期的数据不会
+	for i := 0; i < 3; i++ {
+		time.Sleep(60 * time.Millisecond)
+		cache.Get("absolute")
+		cache.Get("sliding")
+		cache.Get("maxAge")
+	}
+
+	if _, ok := cache.Get("absolute"); ok {
+		t.Fatal("absolute should expire even if it is accessed")
+	}
+
+	if _, ok := cache.Get("sliding"); !ok {
+		t.Fatal("sliding should be renewed by accessing")
+	}
+
+	if _, ok := cache.Get("maxAge"); ok {
+		t.Fatal("maxAge should expire after max age even if it is accessed")
+	}
+}
+
+// go test -v -run=^TestCacheGc$
+func TestCacheGc(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 4
+	options.MaxGcCount = 10
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		data := strconv.Itoa(i)
+		cache.SetWithTTL(data, []byte(data), 20*time.Millisecond)
+	}
+	cache.SetWithTTL("sliding", []byte("sliding"), 50*time.Millisecond, Sliding())
+	cache.Set("forever", []byte("forever"))
+
+	time.Sleep(30 * time.Millisecond)
+	cache.Get("sliding")
+	time.Sleep(30 * time.Millisecond)
+
+	// 主动过期每个 segment 只会处理一批数据
+	cache.expire()
+	status := cache.Status()
+	if status.GcExpired != int64(options.SegmentSize*options.MaxGcCount) {
+		t.Fatalf("gc expired %d is wrong", status.GcExpired)
+	}
+
+	// 空闲过期的数据被访问过，虽然在过期队列中已经到期了，但是实际上还没有过期
+	cache.gc()
+	status = cache.Status()
+	if status.Count != 2 || status.GcExpired != 1000 {
+		t.Fatalf("count %d and gc expired %d are wrong", status.Count, status.GcExpired)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cache.gc()
+	if status = cache.Status(); status.Count != 1 {
+		t.Fatalf("count %d is wrong, sliding should be expired", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheTTLCommands$
+func TestCacheTTLCommands(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("forever", []byte("forever"))
+	if ttl, ok := cache.TTL("forever"); !ok || ttl != NeverDie {
+		t.Fatalf("ttl %d of forever should be never die", ttl)
+	}
+
+	if _, ok := cache.TTL("missing"); ok {
+		t.Fatal("ttl of missing key should not be found")
+	}
+
+	if ok, err := cache.Expire("forever", 50*time.Millisecond); !ok || err != nil {
+		t.Fatalf("expire returns %v and %+v", ok, err)
+	}
+
+	if ttl, ok := cache.TTL("forever"); !ok || ttl <= 0 || ttl > 50*time.Millisecond {
+		t.Fatalf("ttl %d of forever is wrong", ttl)
+	}
+
+	if ok, err := cache.Persist("forever"); !ok || err != nil {
+		t.Fatalf("persist returns %v and %+v", ok, err)
+	}
+
+	cache.SetWithTTL("sliding", []byte("sliding"), 50*time.Millisecond, Sliding())
+	for i := 0; i < 3; i++ {
+		time.Sleep(30 * time.Millisecond)
+		cache.Touch("sliding")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	for _, key := range []string{"forever", "sliding"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Fatalf("%s should not expire", key)
+		}
+	}
+
+	// 有效期小于等于 0 的话会马上删除数据
+	if ok, err := cache.Expire("forever", 0); !ok || err != nil {
+		t.Fatalf("expire returns %v and %+v", ok, err)
+	}
+
+	if _, ok := cache.Get("forever"); ok {
+		t.Fatal("forever should be removed after expiring with 0")
+	}
+
+	if ok, _ := cache.Touch("missing"); ok {
+		t.Fatal("touching missing key should return false")
+	}
+}
+
+// go test -v -run=^TestCacheIncr$
+func TestCacheIncr(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 并发地加减，结果应该和串行执行一样
+	testTask(func(no int) {
+		if no%2 == 0 {
+			cache.IncrBy("counter", 3, NeverDie)
+		} else {
+			cache.Decr("counter", NeverDie)
+		}
+	})
+
+	if value, ok := cache.Get("counter"); !ok || string(value) != strconv.Itoa(concurrency) {
+		t.Fatalf("value %s of counter is wrong", value)
+	}
+
+	// 有效期只在创建的时候设置，之后的加减不会续期
+	if n, err := cache.Incr("limited", 50*time.Millisecond); n != 1 || err != nil {
+		t.Fatalf("incr returns %d and %+v", n, err)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	cache.Incr("limited", time.Hour)
+	time.Sleep(30 * time.Millisecond)
+	if n, _ := cache.Incr("limited", NeverDie); n != 1 {
+		t.Fatalf("limited %d should expire and be created again", n)
+	}
+
+	cache.Set("text", []byte("text"))
+	if _, err := cache.Incr("text", NeverDie); err != NotIntegerErr {
+		t.Fatalf("incr text returns %+v", err)
+	}
+
+	cache.Set("max", []byte(strconv.FormatInt(math.MaxInt64, 10)))
+	if _, err := cache.Incr("max", NeverDie); err != IntegerOverflowErr {
+		t.Fatalf("incr max returns %+v", err)
+	}
+}
+
+// go test -v -run=^TestCacheCompareAndSet$
+func TestCacheCompareAndSet(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.CompareAndSet("key", []byte("value"), 0, NeverDie); err != VersionMismatchErr {
+		t.Fatalf("compare and set a missing key returns %+v", err)
+	}
+
+	if _, err := cache.SetIfPresent("key", []byte("value"), NeverDie); err != KeyNotFoundErr {
+		t.Fatalf("set if present returns %+v", err)
+	}
+
+	version, err := cache.SetIfAbsent("key", []byte("value"), NeverDie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cache.SetIfAbsent("key", []byte("other"), NeverDie); err != KeyExistsErr {
+		t.Fatalf("set if absent returns %+v", err)
+	}
+
+	// 只修改过期时间不会改变版本号
+	cache.Expire("key", time.Hour)
+	if value, v, ok := cache.GetWithVersion("key"); !ok || v != version || string(value) != "value" {
+		t.Fatalf("value %s and version %d are wrong", value, v)
+	}
+
+	newVersion, err := cache.CompareAndSet("key", []byte("new"), version, NeverDie)
+	if err != nil || newVersion <= version {
+		t.Fatalf("compare and set returns %d and %+v", newVersion, err)
+	}
+
+	if _, err := cache.CompareAndSet("key", []byte("stale"), version, NeverDie); err != VersionMismatchErr {
+		t.Fatalf("compare and set with a stale version returns %+v", err)
+	}
+
+	// 删除之后重新添加的数据，版本号也会比之前的大
+	cache.Delete("key")
+	cache.Set("key", []byte("again"))
+	if _, v, _ := cache.GetWithVersion("key"); v <= newVersion {
+		t.Fatalf("version %d should be greater than %d", v, newVersion)
+	}
+}
+
+// go test -v -run=^TestCacheScan$
+func TestCacheScan(t *testing.T) {
+
+	options := DefaultOptions()
+	options.DumpFile = ""
+	options.SegmentSize = 16
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		cache.Set("user:"+strconv.Itoa(i), []byte("user"))
+		cache.Set("order:"+strconv.Itoa(i), []byte("order"))
+	}
+	cache.SetWithTTL("user:expired", []byte("expired"), time.Nanosecond)
+
+	scanned := make(map[string]bool)
+	cursor, calls := uint64(0), 0
+	for {
+		keys, next, err := cache.Scan(cursor, "user:*", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, key := range keys {
+			if scanned[key] {
+				t.Fatalf("key %s is scanned twice", key)
+			}
+			scanned[key] = true
+		}
+
+		calls++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	if len(scanned) != 100 || scanned["user:expired"] {
+		t.Fatalf("scanned %d keys are wrong", len(scanned))
+	}
+
+	if calls < 2 {
+		t.Fatalf("scanning with count 10 should take more than one call, got %d", calls)
+	}
+
+	if _, _, err := cache.Scan(0, "[", 10); err == nil {
+		t.Fatal("scanning with a bad pattern should return an error")
+	}
+}
+
+// go test -v -run=^TestCacheDeleteByPrefixAndTag$
+func TestCacheDeleteByPrefixAndTag(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		data := strconv.Itoa(i)
+		cache.SetWithTTL("profile:"+data, []byte(data), NeverDie, WithTags("user:1"))
+		cache.SetWithTTL("order:"+data, []byte(data), NeverDie, WithTags("user:2", "orders"))
+	}
+
+	// 覆盖数据之后，旧的标签就不再生效了
+	cache.Set("profile:0", []byte("untagged"))
+
+	// 标签会跟着数据一起持久化，恢复之后也可以按照标签删除
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if deleted, err := recovered.DeleteByTag("user:1"); deleted != 9 || err != nil {
+		t.Fatalf("delete by tag returns %d and %+v", deleted, err)
+	}
+
+	if _, ok := recovered.Get("profile:0"); !ok {
+		t.Fatal("profile:0 is not tagged any more")
+	}
+
+	if deleted, err := recovered.DeleteByPrefix("order:"); deleted != 10 || err != nil {
+		t.Fatalf("delete by prefix returns %d and %+v", deleted, err)
+	}
+
+	if deleted, _ := recovered.DeleteByTag("orders"); deleted != 0 {
+		t.Fatalf("orders %d should be removed from the tag index", deleted)
+	}
+
+	if status := recovered.Status(); status.Count != 1 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheNamespace$
+func TestCacheNamespace(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.CreateNamespace("bad.name", NamespaceOptions{}); err != InvalidNamespaceErr {
+		t.Fatalf("creating namespace with invalid name returns %+v", err)
+	}
+
+	// 命名空间必须设置容量上限，并且每个 segment 分到的容量至少要能放下一个键值对
+	if _, err = cache.CreateNamespace("unlimited", NamespaceOptions{}); err != InvalidNamespaceQuotaErr {
+		t.Fatalf("creating namespace without quota returns %+v", err)
+	}
+
+	if _, err = cache.CreateNamespace("tiny", NamespaceOptions{MaxEntrySize: 1000, SegmentSize: 1024}); err != InvalidNamespaceQuotaErr {
+		t.Fatalf("creating namespace with tiny segments returns %+v", err)
+	}
+
+	sessions, err := cache.CreateNamespace("sessions", NamespaceOptions{MaxEntrySize: 1 << 20, DefaultTTL: time.Hour, SegmentSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.CreateNamespace("sessions", NamespaceOptions{MaxEntrySize: 1 << 20}); err != NamespaceExistsErr {
+		t.Fatalf("creating existing namespace returns %+v", err)
+	}
+
+	// 不同命名空间的数据和统计是互相隔离的，没有指定有效期的数据会使用命名空间的默认有效期
+	cache.Set("key", []byte("root"))
+	sessions.Set("key", []byte("session"))
+	if ttl, ok := sessions.TTL("key"); !ok || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("ttl %s of namespace is wrong", ttl)
+	}
+
+	if ttl, _ := cache.TTL("key"); ttl != NeverDie {
+		t.Fatalf("ttl %s of root is wrong", ttl)
+	}
+
+	if err = sessions.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "root" {
+		t.Fatalf("flushing namespace should not affect root but got %s", value)
+	}
+
+	if status := sessions.Status(); status.Count != 0 {
+		t.Fatalf("count %d of namespace is wrong", status.Count)
+	}
+
+	// 命名空间和它的数据在重启之后都会恢复
+	sessions.Set("key", []byte("session"))
+	if err = cache.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, ok := recovered.Namespace("sessions")
+	if !ok {
+		t.Fatal("namespace sessions is not recovered")
+	}
+
+	if value, ok := sessions.Get("key"); !ok || string(value) != "session" {
+		t.Fatalf("value %s of namespace is wrong", value)
+	}
+
+	if err = recovered.DropNamespace("sessions"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = os.Stat(options.DumpFile + ".ns.sessions"); !os.IsNotExist(err) {
+		t.Fatalf("dump file of dropped namespace should be removed but got %+v", err)
+	}
+
+	if err = recovered.DropNamespace("sessions"); err != NamespaceNotFoundErr {
+		t.Fatalf("dropping dropped namespace returns %+v", err)
+	}
+}
+
+// go test -v -run=^TestCacheNamespaceQuota$
+func TestCacheNamespaceQuota(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	options.SegmentSize = 1024
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有指定 segment 数量的话，容量很小的命名空间会减少 segment 的数量，而不是把容量分给 1024 个 segment
+	small, err := cache.CreateNamespace("small", NamespaceOptions{MaxEntrySize: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = small.Set("key", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := small.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s is wrong", value)
+	}
+
+	// 写满之后只会淘汰这个命名空间的数据，不会超过它的容量上限
+	for i := 0; i < 100; i++ {
+		small.Set("key"+strconv.Itoa(i), []byte("value"))
+	}
+
+	if status := small.Status(); status.Count == 0 || status.MemorySize > 1000 {
+		t.Fatalf("count %d and size %d are wrong", status.Count, status.MemorySize)
+	}
+
+	if _, err = cache.CreateNamespace("huge", NamespaceOptions{MaxEntrySize: options.MaxEntrySize + 1}); err != InvalidNamespaceQuotaErr {
+		t.Fatalf("creating namespace larger than root returns %+v", err)
+	}
+
+	// 所有命名空间的容量上限加起来也不能超过默认命名空间的容量上限
+	if _, err = cache.CreateNamespace("half", NamespaceOptions{MaxEntrySize: options.MaxEntrySize / 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.CreateNamespace("other", NamespaceOptions{MaxEntrySize: options.MaxEntrySize / 2}); err != NamespaceQuotaExceededErr {
+		t.Fatalf("creating namespaces larger than root in total returns %+v", err)
+	}
+
+	// 删除命名空间之后它的容量就可以分给其他命名空间了
+	if err = cache.DropNamespace("small"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.CreateNamespace("other", NamespaceOptions{MaxEntrySize: options.MaxEntrySize / 2}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// go test -v -run=^TestCacheNamespaceForever$
+func TestCacheNamespaceForever(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := cache.CreateNamespace("sessions", NamespaceOptions{MaxEntrySize: 1 << 20, DefaultTTL: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有指定有效期的数据使用默认有效期，Forever 表示永不过期
+	sessions.Set("default", []byte("value"))
+	sessions.SetWithTTL("forever", []byte("value"), Forever)
+	if ttl, ok := sessions.TTL("default"); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("ttl %s of default is wrong", ttl)
+	}
+
+	if ttl, ok := sessions.TTL("forever"); !ok || ttl != NeverDie {
+		t.Fatalf("ttl %s of forever is wrong", ttl)
+	}
+
+	if _, err = sessions.HSet("hash", map[string][]byte{"field": []byte("value")}, Forever); err != nil {
+		t.Fatal(err)
+	}
+
+	if ttl, ok := sessions.TTL("hash"); !ok || ttl != NeverDie {
+		t.Fatalf("ttl %s of hash is wrong", ttl)
+	}
+}
+
+// go test -v -run=^TestCacheHash$
+func TestCacheHash(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	added, err := cache.HSet("user", map[string][]byte{"name": []byte("kafo"), "age": []byte("18")}, NeverDie)
+	if added != 2 || err != nil {
+		t.Fatalf("hset returns %d and %+v", added, err)
+	}
+
+	if added, _ = cache.HSet("user", map[string][]byte{"age": []byte("19"), "city": []byte("gz")}, NeverDie); added != 1 {
+		t.Fatalf("added %d is wrong", added)
+	}
+
+	if data, ok, err := cache.HGet("user", "age"); !ok || err != nil || string(data) != "19" {
+		t.Fatalf("hget returns %s, %+v and %+v", data, ok, err)
+	}
+
+	// 哈希不能当作普通的数据使用，普通的数据也不能当作哈希使用
+	if _, ok := cache.Get("user"); ok {
+		t.Fatal("hash should not be got as a string")
+	}
+
+	cache.Set("string", []byte("value"))
+	if _, err = cache.HSet("string", map[string][]byte{"field": nil}, NeverDie); err != WrongTypeErr {
+		t.Fatalf("hset on string returns %+v", err)
+	}
+
+	// 字段名和字段值都算在数据的大小中
+	if status := cache.Status(); status.ValueSize != int64(len("value")+len("namekafoage19citygz")) {
+		t.Fatalf("value size %d is wrong", status.ValueSize)
+	}
+
+	// 哈希会跟着数据一起持久化
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hash, err := recovered.HGetAll("user"); len(hash) != 3 || err != nil || string(hash["name"]) != "kafo" {
+		t.Fatalf("hgetall returns %+v and %+v", hash, err)
+	}
+
+	if deleted, err := recovered.HDel("user", "name", "age", "unknown"); deleted != 2 || err != nil {
+		t.Fatalf("hdel returns %d and %+v", deleted, err)
+	}
+
+	// 最后一个字段被删除之后，整个哈希也会被删除
+	recovered.HDel("user", "city")
+	if hash, _ := recovered.HGetAll("user"); len(hash) != 0 {
+		t.Fatalf("hash %+v should be deleted", hash)
+	}
+
+	if status := recovered.Status(); status.Count != 1 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheList$
+func TestCacheList(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.RPush("queue", [][]byte{[]byte("b"), []byte("c")}, NeverDie)
+	if length, err := cache.LPush("queue", [][]byte{[]byte("a")}, NeverDie); length != 3 || err != nil {
+		t.Fatalf("lpush returns %d and %+v", length, err)
+	}
+
+	// 弹出之后再追加不能影响之前的快照
+	before, _ := cache.LRange("queue", 0, -1)
+	if data, ok, err := cache.RPop("queue"); !ok || err != nil || string(data) != "c" {
+		t.Fatalf("rpop returns %s, %+v and %+v", data, ok, err)
+	}
+
+	cache.RPush("queue", [][]byte{[]byte("d")}, NeverDie)
+	if string(before[2]) != "c" {
+		t.Fatalf("snapshot %s is modified", before[2])
+	}
+
+	if list, _ := cache.LRange("queue", 1, -1); len(list) != 2 || string(list[0]) != "b" || string(list[1]) != "d" {
+		t.Fatalf("list %s is wrong", list)
+	}
+
+	if _, err = cache.LRange("queue", 5, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// 阻塞的弹出会等到有新的元素为止
+	resultCh := make(chan string, 1)
+	go func() {
+		data, err := cache.BLPop(context.Background(), "jobs")
+		if err != nil {
+			resultCh <- err.Error()
+			return
+		}
+		resultCh <- string(data)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cache.RPush("jobs", [][]byte{[]byte("job")}, NeverDie)
+	select {
+	case result := <-resultCh:
+		if result != "job" {
+			t.Fatalf("blpop returns %s", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("blpop is not woken up")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err = cache.BRPop(ctx, "jobs"); err != context.DeadlineExceeded {
+		t.Fatalf("brpop returns %+v", err)
+	}
+
+	// 列表会跟着数据一起持久化，弹出或者截取到没有元素的时候 key 也会被删除
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if length, _ := recovered.LLen("queue"); length != 3 {
+		t.Fatalf("length %d is wrong", length)
+	}
+
+	recovered.LTrim("queue", 1, 1)
+	if data, ok, _ := recovered.LPop("queue"); !ok || string(data) != "b" {
+		t.Fatalf("lpop returns %s and %+v", data, ok)
+	}
+
+	if status := recovered.Status(); status.Count != 0 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+}
+
+// go test -v -run=^TestCacheSetAndZSet$
+func TestCacheSetAndZSet(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added, err := cache.SAdd("tags", []string{"go", "cache", "go"}, NeverDie); added != 2 || err != nil {
+		t.Fatalf("sadd returns %d and %+v", added, err)
+	}
+
+	if ok, _ := cache.SIsMember("tags", "go"); !ok {
+		t.Fatal("go should be a member")
+	}
+
+	if removed, _ := cache.SRem("tags", "go", "rust"); removed != 1 {
+		t.Fatalf("srem returns %d", removed)
+	}
+
+	if members, _ := cache.SMembers("tags"); len(members) != 1 || members[0] != "cache" {
+		t.Fatalf("members %v are wrong", members)
+	}
+
+	if _, err = cache.ZAdd("tags", map[string]float64{"a": 1}, NeverDie); err != WrongTypeErr {
+		t.Fatalf("zadd returns %+v", err)
+	}
+
+	cache.ZAdd("board", map[string]float64{"alice": 10, "bob": 20, "carol": 15}, NeverDie)
+	if added, _ := cache.ZAdd("board", map[string]float64{"bob": 5, "dave": 15}, NeverDie); added != 1 {
+		t.Fatalf("zadd returns %d", added)
+	}
+
+	if score, err := cache.ZIncrBy("board", "alice", 20, NeverDie); score != 30 || err != nil {
+		t.Fatalf("zincrby returns %f and %+v", score, err)
+	}
+
+	// 分数相同的按照成员的字典序排列
+	members, _ := cache.ZRange("board", 0, -1)
+	expected := []string{"bob", "carol", "dave", "alice"}
+	if len(members) != len(expected) {
+		t.Fatalf("members %v are wrong", members)
+	}
+
+	for i, member := range members {
+		if member.Member != expected[i] {
+			t.Fatalf("members %v are wrong", members)
+		}
+	}
+
+	if top, _ := cache.ZRevRange("board", 0, 0); len(top) != 1 || top[0].Member != "alice" || top[0].Score != 30 {
+		t.Fatalf("top %v is wrong", top)
+	}
+
+	if members, _ = cache.ZRangeByScore("board", 10, 15); len(members) != 2 || members[0].Member != "carol" {
+		t.Fatalf("members %v are wrong", members)
+	}
+
+	if rank, ok, _ := cache.ZRevRank("board", "carol"); !ok || rank != 2 {
+		t.Fatalf("rank %d and %+v are wrong", rank, ok)
+	}
+
+	if _, err = cache.ZIncrBy("board", "alice", math.Inf(1), NeverDie); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = cache.ZIncrBy("board", "alice", math.Inf(-1), NeverDie); err != InvalidScoreErr {
+		t.Fatalf("zincrby returns %+v", err)
+	}
+
+	// 集合和有序集合会跟着数据一起持久化
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count, _ := recovered.SCard("tags"); count != 1 {
+		t.Fatalf("count %d is wrong", count)
+	}
+
+	if rank, ok, _ := recovered.ZRank("board", "alice"); !ok || rank != 3 {
+		t.Fatalf("rank %d and %+v are wrong", rank, ok)
+	}
+
+	recovered.SRem("tags", "cache")
+	recovered.ZRem("board", "alice", "bob", "carol", "dave")
+	if status := recovered.Status(); status.Count != 0 {
+		t.Fatalf("count %d is wrong", status.Count)
+	}
+}
@@ -0,0 +1,96 @@
+package caches
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DumpCompressionNone 表示持久化文件不压缩。
+	DumpCompressionNone = "none"
+
+	// DumpCompressionGzip 表示使用 gzip 压缩持久化文件，压缩率和速度都比较均衡。
+	DumpCompressionGzip = "gzip"
+
+	// DumpCompressionZstd 表示使用 zstd 压缩持久化文件，压缩率高并且速度也很快。
+	DumpCompressionZstd = "zstd"
+
+	// DumpCompressionSnappy 表示使用 snappy 压缩持久化文件，压缩率一般但是速度最快。
+	DumpCompressionSnappy = "snappy"
+)
+
+var (
+	// gzipMagic 是 gzip 数据开头的魔数。
+	gzipMagic = []byte{0x1f, 0x8b}
+
+	// zstdMagic 是 zstd 数据开头的魔数。
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+	// snappyMagic 是 snappy 流式格式开头的标识数据块。
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+)
+
+// nopWriteCloser 是关闭时什么也不做的 io.WriteCloser，用于不压缩的情况。
+type nopWriteCloser struct {
+	io.Writer
+}
+
+// Close 什么也不做。
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// checkDumpCompression 检查 compression 是不是支持的压缩算法，空字符串等同于不压缩。
+func checkDumpCompression(compression string) error {
+	switch compression {
+	case "", DumpCompressionNone, DumpCompressionGzip, DumpCompressionZstd, DumpCompressionSnappy:
+		return nil
+	default:
+		return errors.New("unknown dump compression " + compression)
+	}
+}
+
+// newCompressWriter 返回一个按照 compression 压缩数据并写入到 w 的 writer。
+// 数据是边写边压缩的，不需要先在内存中准备好完整的数据，注意写完之后一定要关闭，否则缓冲区中的数据不会写入到 w。
+func newCompressWriter(w io.Writer, compression string) (io.WriteCloser, error) {
+	switch compression {
+	case "", DumpCompressionNone:
+		return nopWriteCloser{Writer: w}, nil
+	case DumpCompressionGzip:
+		return gzip.NewWriter(w), nil
+	case DumpCompressionZstd:
+		return zstd.NewWriter(w)
+	case DumpCompressionSnappy:
+		return snappy.NewBufferedWriter(w), nil
+	default:
+		return nil, checkDumpCompression(compression)
+	}
+}
+
+// newDecompressReader 根据数据开头的魔数自动识别压缩算法，返回解压之后的 reader。
+// 所以修改了压缩算法之后，之前的持久化文件也可以正常恢复，识别不出压缩算法的数据会被当作没有压缩的数据。
+func newDecompressReader(reader *bufio.Reader) (io.ReadCloser, error) {
+
+	header, _ := reader.Peek(len(snappyMagic))
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(reader)
+	case bytes.HasPrefix(header, zstdMagic):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case bytes.HasPrefix(header, snappyMagic):
+		return ioutil.NopCloser(snappy.NewReader(reader)), nil
+	default:
+		return ioutil.NopCloser(reader), nil
+	}
+}
@@ -1,13 +1,39 @@
 package caches
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
-	"sync"
-	"time"
+)
+
+const (
+	// dumpMagic 是持久化文件开头的魔数，用于识别持久化文件。
+	dumpMagic = "KAFODUMP"
+
+	// dumpVersion 是当前持久化文件的格式版本，紧跟在魔数后面，使用 2 个字节存储。
+	// 以后修改了持久化的格式就需要增加这个版本号，并在 readFrom 中加上旧版本的迁移逻辑。
+	// 版本 1 中数据的 Ttl 和 Ctime 的单位是秒，版本 2 开始改成了纳秒。
+	dumpVersion = uint16(2)
+
+	// secondsDumpVersion 是 Ttl 和 Ctime 的单位还是秒的最后一个版本。
+	secondsDumpVersion = uint16(1)
+
+	// dumpHeaderSize 是持久化文件头的大小，包括魔数和版本号。
+	dumpHeaderSize = len(dumpMagic) + 2
+
+	// dumpBlockHeaderSize 是每个数据块头部的大小，包括 4 字节的长度和 4 字节的 CRC 校验和。
+	dumpBlockHeaderSize = 8
 )
 
 // dump 是专门用于持久化的结构体。
+// 持久化文件的格式是魔数和版本号，后面跟着一个个的数据块，第一个数据块是 dumpMeta，之后每个 segment 对应一个数据块。
+// 每个数据块都是 4 字节长度、4 字节 CRC 校验和以及 gob 编码的数据，这样文件损坏的时候可以明确地知道是哪里出了问题。
 type dump struct {
 
 	// SegmentSize 是 segment 的数量。
@@ -20,70 +46,223 @@ type dump struct {
 	Options *Options
 }
 
-// newEmptyDump 返回一个空的持久化实例。
-func newEmptyDump() *dump {
-	return &dump{}
+// dumpMeta 是持久化文件的第一个数据块，记录着缓存的整体信息。
+type dumpMeta struct {
+
+	// SegmentSize 是 segment 的数量，也就是后面还有多少个数据块。
+	SegmentSize int
+
+	// Options 是缓存的选项配置。
+	Options *Options
+}
+
+// dumpSegment 是持久化文件中 segment 对应的数据块。
+type dumpSegment struct {
+
+	// Data 存储着这个 segment 的数据。
+	Data map[string]*value
 }
 
 // newDump 返回一个从缓存实例初始化过来的持久化实例。
+// 这里存储的是每个 segment 的快照，所以之后的持久化过程不需要再持有任何锁。
 func newDump(c *Cache) *dump {
+	segments := make([]*segment, len(c.segments))
+	for i, segment := range c.segments {
+		segments[i] = segment.snapshot()
+	}
+
 	return &dump{
 		SegmentSize: c.segmentSize,
-		Segments:    c.segments,
+		Segments:    segments,
 		Options:     c.options,
 	}
 }
 
-// nowSuffix 返回当前时间，类似于 20060102150405。
-func nowSuffix() string {
-	return "." + time.Now().Format("20060102150405")
+// to 会将 dump 实例持久化到文件中，并只保留最新的 retention 个历史快照。
+// 数据会先完整地写入到带时间戳的历史快照文件中，然后再原子地替换掉 dumpFile，
+// 所以任何时候崩溃，dumpFile 都是一个完整的持久化文件，不会出现没有持久化文件或者只写了一半的情况。
+func (d *dump) to(dumpFile string, retention int) error {
+
+	snapshotFile := dumpFile + nowSuffix()
+	if err := d.toFile(snapshotFile); err != nil {
+		return err
+	}
+
+	if err := replaceFile(snapshotFile, dumpFile); err != nil {
+		return err
+	}
+	return removeOldSnapshots(dumpFile, retention)
 }
 
-// to 会将 dump 实例持久化到文件中。
-func (d *dump) to(dumpFile string) error {
+// toFile 会将 dump 实例写入到 dumpFile 中。
+// 数据是先写入到临时文件中，刷盘之后再重命名的，所以 dumpFile 只要存在就一定是完整的。
+func (d *dump) toFile(dumpFile string) error {
 
-	newDumpFile := dumpFile + nowSuffix()
-	file, err := os.OpenFile(newDumpFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	tempFile := dumpFile + tempFileSuffix
+	file, err := os.OpenFile(tempFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	err = gob.NewEncoder(file).Encode(d)
+	// 压缩是边编码边进行的，关闭压缩的 writer 之后数据才会全部写入到文件中
+	writer, err := newCompressWriter(file, d.Options.DumpCompression)
+	if err == nil {
+		err = d.writeTo(writer)
+		if closeErr := writer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	file.Close()
 	if err != nil {
-		file.Close()
-		os.Remove(newDumpFile)
+		os.Remove(tempFile)
 		return err
 	}
+	return os.Rename(tempFile, dumpFile)
+}
 
-	os.Remove(dumpFile)
-	file.Close()
-	return os.Rename(newDumpFile, dumpFile)
+// writeTo 把 dump 实例按照持久化文件的格式写入到 w 中。
+func (d *dump) writeTo(w io.Writer) error {
+
+	writer := bufio.NewWriter(w)
+	header := make([]byte, dumpHeaderSize)
+	copy(header, dumpMagic)
+	binary.BigEndian.PutUint16(header[len(dumpMagic):], dumpVersion)
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+
+	err := writeDumpBlock(writer, &dumpMeta{SegmentSize: d.SegmentSize, Options: d.Options})
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range d.Segments {
+		if err = writeDumpBlock(writer, &dumpSegment{Data: segment.Data}); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// writeDumpBlock 把 block 编码成一个数据块写入到 writer 中。
+func writeDumpBlock(writer io.Writer, block interface{}) error {
+	buffer := bytes.NewBuffer(make([]byte, dumpBlockHeaderSize, 4096))
+	if err := gob.NewEncoder(buffer).Encode(block); err != nil {
+		return err
+	}
+
+	data := buffer.Bytes()
+	binary.BigEndian.PutUint32(data[0:4], uint32(len(data)-dumpBlockHeaderSize))
+	binary.BigEndian.PutUint32(data[4:8], crc32.ChecksumIEEE(data[dumpBlockHeaderSize:]))
+	_, err := writer.Write(data)
+	return err
 }
 
-// from 返回一个从持久化文件中恢复的缓存实例。
-func (d *dump) from(dumpFile string) (*Cache, error) {
+// readDumpBlock 从 reader 中读取第 index 个数据块，校验之后解码到 block 中。
+func readDumpBlock(reader io.Reader, block interface{}, index int) error {
+
+	header := make([]byte, dumpBlockHeaderSize)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return fmt.Errorf("failed to read header of block %d: %s", index, err.Error())
+	}
+
+	// 长度还没有经过校验，文件损坏的话可能是一个很大的值，所以不能直接按照这个长度分配内存，
+	// 而是边读边扩容，这样分配的内存不会超过文件中实际存在的数据
+	length := int64(binary.BigEndian.Uint32(header[0:4]))
+	payload := &bytes.Buffer{}
+	if n, err := io.Copy(payload, io.LimitReader(reader, length)); err != nil || n != length {
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read block %d: %s", index, err.Error())
+	}
+
+	if crc32.ChecksumIEEE(payload.Bytes()) != binary.BigEndian.Uint32(header[4:8]) {
+		return fmt.Errorf("checksum of block %d mismatches", index)
+	}
+
+	if err := gob.NewDecoder(payload).Decode(block); err != nil {
+		return fmt.Errorf("failed to decode block %d: %s", index, err.Error())
+	}
+	return nil
+}
+
+// readFrom 从 reader 中读取持久化文件，并把每一条数据交给 apply 处理，旧格式的文件会被迁移成当前的格式。
+// 持久化文件中的选项配置只是用来说明当时的情况，恢复的时候使用的是当前的选项配置。
+func readFrom(reader *bufio.Reader, apply func(key string, v *value)) error {
+
+	// 没有魔数的是引入文件头之前的格式，整个文件就是 gob 编码的 dump 结构体
+	header, err := reader.Peek(dumpHeaderSize)
+	if err != nil || string(header[:len(dumpMagic)]) != dumpMagic {
+		return readLegacyFrom(reader, apply)
+	}
+
+	version := binary.BigEndian.Uint16(header[len(dumpMagic):])
+	if version < secondsDumpVersion || version > dumpVersion {
+		return fmt.Errorf("unsupported dump version %d, the newest version is %d", version, dumpVersion)
+	}
+	reader.Discard(dumpHeaderSize)
+
+	meta := &dumpMeta{}
+	if err = readDumpBlock(reader, meta, 0); err != nil {
+		return err
+	}
+
+	// 每次只解码一个 segment 的数据，避免恢复的时候占用两倍的内存
+	for i := 0; i < meta.SegmentSize; i++ {
+		block := &dumpSegment{}
+		if err = readDumpBlock(reader, block, i+1); err != nil {
+			return err
+		}
+
+		for key, value := range block.Data {
+			if version <= secondsDumpVersion {
+				value.secondsToNanos()
+			}
+			apply(key, value)
+		}
+	}
+
+	if _, err = reader.ReadByte(); err != io.EOF {
+		return errors.New("unexpected data after the last block")
+	}
+	return nil
+}
+
+// readLegacyFrom 从 reader 中读取旧格式的持久化文件，迁移成当前的格式之后交给 apply 处理。
+func readLegacyFrom(reader io.Reader, apply func(key string, v *value)) error {
+	legacy := &legacyDump{}
+	if err := gob.NewDecoder(reader).Decode(legacy); err != nil {
+		return fmt.Errorf("failed to decode legacy dump: %s", err.Error())
+	}
+	legacy.migrate(apply)
+	return nil
+}
+
+// loadDumpFile 读取持久化文件中的所有数据，并交给 apply 处理。
+// 压缩过的持久化文件会根据文件开头的魔数自动解压，持久化文件不存在的时候会直接返回打开文件的错误，而文件损坏或者格式不支持的时候会返回一个说明原因的错误。
+func loadDumpFile(dumpFile string, apply func(key string, v *value)) error {
 
 	file, err := os.Open(dumpFile)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer file.Close()
 
-	if err = gob.NewDecoder(file).Decode(d); err != nil {
-		return nil, err
+	reader, err := newDecompressReader(bufio.NewReader(file))
+	if err == nil {
+		err = readFrom(bufio.NewReader(reader), apply)
+		reader.Close()
 	}
 
-    // 恢复出 segment 之后需要为每一个 segment 的未导出字段进行初始化
-	for _, segment := range d.Segments {
-		segment.options = d.Options
-		segment.lock = &sync.RWMutex{}
+	if err != nil {
+		return fmt.Errorf("failed to recover from dump file %s: %s", dumpFile, err.Error())
 	}
-
-	return &Cache{
-		segmentSize: d.SegmentSize,
-		segments:    d.Segments,
-		options:     d.Options,
-		dumping:     0,
-	}, nil
+	return nil
 }
@@ -0,0 +1,74 @@
+package caches
+
+// legacyDump 是引入文件头之前的持久化格式，整个文件就是使用 gob 直接编码的 dump 结构体。
+// 这里单独定义了一份当时的结构，这样以后修改 segment、value 和 Options 也不会影响旧文件的读取。
+type legacyDump struct {
+
+	// SegmentSize 是 segment 的数量。
+	SegmentSize int
+
+	// Segments 存储着所有的 segment。
+	Segments []*legacySegment
+
+	// Options 是缓存的选项配置。
+	Options *legacyOptions
+}
+
+// legacySegment 是旧格式中的 segment。
+type legacySegment struct {
+
+	// Data 存储这个数据块的数据。
+	Data map[string]*legacyValue
+}
+
+// legacyValue 是旧格式中的 value。
+type legacyValue struct {
+
+	// Data 存储着真正的数据。
+	Data []byte
+
+	// Ttl 代表这个数据的寿命，单位是秒。
+	Ttl int64
+
+	// Ctime 代表这个数据的创建时间，单位是秒。
+	Ctime int64
+}
+
+// legacyOptions 是旧格式中的选项配置。
+type legacyOptions struct {
+
+	// MaxEntrySize 指键值对最大容量，单位是 GB。
+	MaxEntrySize int64
+
+	// MaxGcCount 指每个 segment 要清理的过期数据个数。
+	MaxGcCount int
+
+	// GcDuration 指多久执行一次 Gc 工作，单位是分钟。
+	GcDuration int
+
+	// DumpFile 指持久化文件的路径。
+	DumpFile string
+
+	// DumpDuration 指多久执行一次持久化，单位是分钟。
+	DumpDuration int
+
+	// MapSizeOfSegment 指 segment 中 map 的初始化大小。
+	MapSizeOfSegment int
+
+	// SegmentSize 指缓存中有多少个 segment。
+	SegmentSize int
+}
+
+// migrate 把旧格式的持久化数据迁移成当前的格式，并逐条交给 apply 处理。
+func (ld *legacyDump) migrate(apply func(key string, v *value)) {
+	for _, legacySegment := range ld.Segments {
+		for key, legacyValue := range legacySegment.Data {
+			v := &value{
+				Data:  legacyValue.Data,
+				Ttl:   legacyValue.Ttl,
+				Ctime: legacyValue.Ctime,
+			}
+			apply(key, v.secondsToNanos())
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package caches
+
+import (
+	"context"
+	"encoding/gob"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newDumpTestOptions 返回使用临时目录作为持久化文件目录的选项配置。
+func newDumpTestOptions(t *testing.T) (Options, func()) {
+	dir, err := ioutil.TempDir("", "kafo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := DefaultOptions()
+	options.SegmentSize = 16
+	options.DumpFile = filepath.Join(dir, "kafo.dump")
+	return options, func() { os.RemoveAll(dir) }
+}
+
+// go test -v -run=^TestDumpAndRecover$
+func TestDumpAndRecover(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		data := strconv.Itoa(i)
+		cache.Set(data, []byte(data))
+	}
+
+	// 持久化的过程中并发地读写，不应该被阻塞，也不应该影响持久化的结果
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; i < 2000; i++ {
+			data := strconv.Itoa(i)
+			cache.Set(data, []byte(data))
+			cache.Get(data)
+		}
+	}()
+
+	if err := cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		data := strconv.Itoa(i)
+		value, ok := recovered.Get(data)
+		if !ok || string(value) != data {
+			t.Fatalf("value of %s is wrong, got %s", data, value)
+		}
+	}
+}
+
+// go test -v -run=^TestRecoverFromLegacyDump$
+func TestRecoverFromLegacyDump(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	// 旧格式的持久化文件就是直接使用 gob 编码的结构体，而 gob 是按照字段名匹配的，所以这里可以用 legacyDump 模拟
+	legacy := &legacyDump{
+		SegmentSize: 2,
+		Segments: []*legacySegment{
+			{Data: map[string]*legacyValue{"key": {Data: []byte("value"), Ttl: NeverDie, Ctime: time.Now().Unix()}}},
+			{Data: map[string]*legacyValue{"ttl": {Data: []byte("ttl"), Ttl: 3600, Ctime: time.Now().Unix()}}},
+		},
+		Options: &legacyOptions{MaxEntrySize: 1, MapSizeOfSegment: 16, SegmentSize: 2},
+	}
+
+	file, err := os.Create(options.DumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(file).Encode(legacy)
+	file.Close()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cache.segmentSize != options.SegmentSize {
+		t.Fatalf("segment size %d should be the current one %d", cache.segmentSize, options.SegmentSize)
+	}
+
+	if value, ok := cache.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value of key is wrong, got %s", value)
+	}
+
+	// 旧格式中 ttl 的单位是秒，迁移之后需要转换成纳秒
+	if value, ok := cache.Get("ttl"); !ok || string(value) != "ttl" {
+		t.Fatalf("value of ttl is wrong, got %s", value)
+	}
+}
+
+// go test -v -run=^TestRecoverFromCorruptedDump$
+func TestRecoverFromCorruptedDump(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key", []byte("value"))
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 修改最后一个字节，校验和就会对不上
+	data, err := ioutil.ReadFile(options.DumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(options.DumpFile, data, 0644)
+
+	if _, err = NewCacheWith(options); err == nil {
+		t.Fatal("recovering from a corrupted dump file should return an error")
+	}
+}
+
+// go test -v -run=^TestRecoverFromTruncatedDump$
+func TestRecoverFromTruncatedDump(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	// 头部记录的块长度接近 4 GB，但是后面只有几个字节，恢复的时候不应该按照这个长度分配内存
+	data := append([]byte(dumpMagic), 0, byte(dumpVersion))
+	data = append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0)
+	data = append(data, []byte("truncated")...)
+	ioutil.WriteFile(options.DumpFile, data, 0644)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if _, err := NewCacheWith(options); err == nil {
+		t.Fatal("recovering from a truncated dump file should return an error")
+	}
+
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Fatalf("recovering from a truncated dump file allocated %d bytes", allocated)
+	}
+}
+
+// go test -v -run=^TestRestoreTooLarge$
+func TestRestoreTooLarge(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		cache.Set("key"+strconv.Itoa(i), []byte(strings.Repeat("v", 100)))
+	}
+
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 容量上限放不下持久化文件中的所有数据，恢复失败的时候现有的数据不能被清掉
+	smallOptions := options
+	smallOptions.DumpFile = options.DumpFile + ".small"
+	smallOptions.MaxEntrySize = 16 << 10
+	small, err := NewCacheWith(smallOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = copyFile(options.DumpFile, smallOptions.DumpFile+".20060102150405000"); err != nil {
+		t.Fatal(err)
+	}
+
+	small.Set("live", []byte("value"))
+	if err = small.Restore("20060102150405000"); err != RestoreTooLargeErr {
+		t.Fatalf("restoring a dump larger than the cache should return RestoreTooLargeErr, but got %v", err)
+	}
+
+	if value, ok := small.Get("live"); !ok || string(value) != "value" {
+		t.Fatalf("live data should be kept after a failed restore, but got %s, %v", value, ok)
+	}
+}
+
+// go test -v -run=^TestRecoverWithDifferentOptions$
+func TestRecoverWithDifferentOptions(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		data := strconv.Itoa(i)
+		cache.Set(data, []byte(data))
+	}
+	cache.SetWithTTL("expired", []byte("expired"), time.Second)
+
+	if err = cache.Dump(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+
+	// 使用不同的 segment 数量恢复，数据需要重新分配到新的 segment 中，过期的数据会被丢弃
+	options.SegmentSize = 4
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recovered.segments) != 4 {
+		t.Fatalf("segments %d should be 4", len(recovered.segments))
+	}
+
+	for i := 0; i < 100; i++ {
+		data := strconv.Itoa(i)
+		if value, ok := recovered.Get(data); !ok || string(value) != data {
+			t.Fatalf("value of %s is wrong, got %s", data, value)
+		}
+	}
+
+	if _, ok := recovered.Get("expired"); ok {
+		t.Fatal("expired entry should be dropped")
+	}
+
+	// 使用更小的容量上限恢复，超出的数据会被丢弃
+	options.MaxEntrySize = 4 * (entryOverhead + 4)
+	limited, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status := limited.Status(); status.Count > 4 {
+		t.Fatalf("count %d exceeds the max entry size", status.Count)
+	}
+}
+
+// go test -v -run=^TestCompressedDump$
+func TestCompressedDump(t *testing.T) {
+
+	compressions := []string{DumpCompressionNone, DumpCompressionGzip, DumpCompressionZstd, DumpCompressionSnappy}
+	for _, compression := range compressions {
+		options, cleanup := newDumpTestOptions(t)
+		options.DumpCompression = compression
+		cache, err := NewCacheWith(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			data := strconv.Itoa(i)
+			cache.Set(data, []byte(data))
+		}
+
+		if err = cache.Dump(); err != nil {
+			t.Fatalf("%s: %+v", compression, err)
+		}
+
+		// 恢复的时候会自动识别压缩算法，所以使用别的压缩算法配置也可以恢复
+		options.DumpCompression = DumpCompressionGzip
+		recovered, err := NewCacheWith(options)
+		if err != nil {
+			t.Fatalf("%s: %+v", compression, err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			data := strconv.Itoa(i)
+			if value, ok := recovered.Get(data); !ok || string(value) != data {
+				t.Fatalf("%s: value of %s is wrong, got %s", compression, data, value)
+			}
+		}
+		cleanup()
+	}
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+	options.DumpCompression = "unknown"
+	if _, err := NewCacheWith(options); err == nil {
+		t.Fatal("unknown compression should return an error")
+	}
+}
+
+// go test -v -run=^TestDumpRetention$
+func TestDumpRetention(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	options.DumpRetention = 2
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 4; i++ {
+		cache.Set("key", []byte(strconv.Itoa(i)))
+		if err = cache.Dump(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	snapshots, err := dumpSnapshots(options.DumpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshots) != 2 {
+		t.Fatalf("snapshots %+v should only keep 2", snapshots)
+	}
+
+	// 使用时间戳指定更早的快照进行恢复
+	options.RecoverSnapshot = strings.TrimPrefix(snapshots[0], options.DumpFile+".")
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := recovered.Get("key"); !ok || string(value) != "2" {
+		t.Fatalf("value %s should be recovered from the older snapshot", value)
+	}
+
+	// 从历史快照恢复之后，它会成为最新的持久化文件
+	options.RecoverSnapshot = ""
+	recovered, err = NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := recovered.Get("key"); !ok || string(value) != "2" {
+		t.Fatalf("value %s should be the one recovered from the older snapshot", value)
+	}
+
+	options.RecoverSnapshot = "20060102150405000"
+	if _, err = NewCacheWith(options); err == nil {
+		t.Fatal("recovering from a missing snapshot should return an error")
+	}
+}
+
+// go test -v -run=^TestCloseWithFinalDump$
+func TestCloseWithFinalDump(t *testing.T) {
+
+	options, cleanup := newDumpTestOptions(t)
+	defer cleanup()
+
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.AutoGc()
+	cache.AutoDump()
+	cache.Set("key", []byte("value"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = cache.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = cache.Close(ctx); err != nil {
+		t.Fatalf("closing twice returns %+v", err)
+	}
+
+	recovered, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if value, ok := recovered.Get("key"); !ok || string(value) != "value" {
+		t.Fatalf("value %s should be dumped when closing", value)
+	}
+}
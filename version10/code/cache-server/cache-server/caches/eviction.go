@@ -0,0 +1,515 @@
+package caches
+
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"sync"
+)
+
+const (
+	// NoEviction 表示不淘汰任何数据，缓存写满之后直接拒绝新的写入，这也是最早的写满保护机制。
+	NoEviction = "none"
+
+	// LRUEviction 表示淘汰最久没有被访问的数据。
+	LRUEviction = "lru"
+
+	// LFUEviction 表示淘汰访问次数最少的数据，访问次数相同时淘汰最久没有被访问的数据。
+	LFUEviction = "lfu"
+
+	// FIFOEviction 表示淘汰最早加入的数据。
+	FIFOEviction = "fifo"
+
+	// TinyLFUEviction 表示使用带准入过滤的 W-TinyLFU 淘汰数据。
+	TinyLFUEviction = "tinylfu"
+)
+
+// evictor 是淘汰策略的抽象接口。
+// 每个 segment 都有一个自己的 evictor，segment 的写操作都是在写锁中调用 evictor 的，
+// 但是读操作只持有读锁，所以 evictor 需要自己保证并发安全。
+type evictor interface {
+
+	// add 记录一个新加入的 key。
+	add(key string)
+
+	// access 记录一次 key 的访问，覆盖已经存在的 key 也算一次访问。
+	access(key string)
+
+	// remove 移除 key 的记录。
+	remove(key string)
+
+	// victim 选出一个需要被淘汰的 key 来给 candidate 腾出空间，选出来的 key 不会是 candidate 本身。
+	// 如果返回 false，说明没有可以淘汰的数据，或者淘汰策略认为不应该淘汰数据。
+	victim(candidate string) (string, bool)
+}
+
+// checkEvictionPolicy 检查 policy 是不是支持的淘汰策略，空字符串等同于不淘汰。
+func checkEvictionPolicy(policy string) error {
+	switch policy {
+	case "", NoEviction, LRUEviction, LFUEviction, FIFOEviction, TinyLFUEviction:
+		return nil
+	default:
+		return errors.New("unknown eviction policy " + policy)
+	}
+}
+
+// newEvictor 返回 policy 对应的淘汰策略实例。
+func newEvictor(policy string) evictor {
+	switch policy {
+	case NoEviction, "":
+		return noEvictor{}
+	case LRUEviction:
+		return newLRUEvictor()
+	case LFUEviction:
+		return newLFUEvictor()
+	case FIFOEviction:
+		return newFIFOEvictor()
+	case TinyLFUEviction:
+		return newTinyLFUEvictor()
+	}
+	panic("caches: unknown eviction policy " + policy)
+}
+
+// =======================================================================
+
+// noEvictor 是不淘汰任何数据的淘汰策略。
+type noEvictor struct{}
+
+func (noEvictor) add(key string)    {}
+func (noEvictor) access(key string) {}
+func (noEvictor) remove(key string) {}
+
+func (noEvictor) victim(candidate string) (string, bool) {
+	return "", false
+}
+
+// =======================================================================
+
+// listEvictor 是使用双向链表实现的淘汰策略，链表头部是最应该保留的数据，尾部是最应该淘汰的数据。
+// LRU 和 FIFO 的区别只在于访问数据的时候要不要把数据移动到链表头部。
+type listEvictor struct {
+
+	// moveOnAccess 表示访问数据的时候是否需要把数据移动到链表头部。
+	moveOnAccess bool
+
+	// elements 记录着 key 在链表中的位置。
+	elements map[string]*list.Element
+
+	// keys 是存储 key 的链表。
+	keys *list.List
+
+	// lock 用于保证这个淘汰策略的并发安全。
+	lock *sync.Mutex
+}
+
+// newLRUEvictor 返回一个 LRU 淘汰策略实例。
+func newLRUEvictor() *listEvictor {
+	return &listEvictor{
+		moveOnAccess: true,
+		elements:     map[string]*list.Element{},
+		keys:         list.New(),
+		lock:         &sync.Mutex{},
+	}
+}
+
+// newFIFOEvictor 返回一个 FIFO 淘汰策略实例。
+func newFIFOEvictor() *listEvictor {
+	return &listEvictor{
+		moveOnAccess: false,
+		elements:     map[string]*list.Element{},
+		keys:         list.New(),
+		lock:         &sync.Mutex{},
+	}
+}
+
+func (le *listEvictor) add(key string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if element, ok := le.elements[key]; ok {
+		le.keys.MoveToFront(element)
+		return
+	}
+	le.elements[key] = le.keys.PushFront(key)
+}
+
+func (le *listEvictor) access(key string) {
+	if !le.moveOnAccess {
+		return
+	}
+
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if element, ok := le.elements[key]; ok {
+		le.keys.MoveToFront(element)
+	}
+}
+
+func (le *listEvictor) remove(key string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if element, ok := le.elements[key]; ok {
+		le.keys.Remove(element)
+		delete(le.elements, key)
+	}
+}
+
+func (le *listEvictor) victim(candidate string) (string, bool) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	return lastKeyExcept(le.keys, candidate)
+}
+
+// lastKeyExcept 返回链表尾部第一个不是 except 的 key。
+func lastKeyExcept(keys *list.List, except string) (string, bool) {
+	for element := keys.Back(); element != nil; element = element.Prev() {
+		if key := element.Value.(string); key != except {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// =======================================================================
+
+// lfuEntry 是 LFU 淘汰策略中记录的数据。
+type lfuEntry struct {
+
+	// key 是数据的 key。
+	key string
+
+	// count 是数据的访问次数。
+	count uint64
+
+	// tick 是数据最后一次被访问的逻辑时间，用于在访问次数相同的时候按照 LRU 淘汰。
+	tick uint64
+
+	// index 是数据在堆中的下标。
+	index int
+}
+
+// lfuHeap 是按照访问次数和访问时间排序的小顶堆，堆顶就是最应该淘汰的数据。
+type lfuHeap []*lfuEntry
+
+func (lh lfuHeap) Len() int {
+	return len(lh)
+}
+
+func (lh lfuHeap) Less(i, j int) bool {
+	if lh[i].count == lh[j].count {
+		return lh[i].tick < lh[j].tick
+	}
+	return lh[i].count < lh[j].count
+}
+
+func (lh lfuHeap) Swap(i, j int) {
+	lh[i], lh[j] = lh[j], lh[i]
+	lh[i].index = i
+	lh[j].index = j
+}
+
+func (lh *lfuHeap) Push(x interface{}) {
+	entry := x.(*lfuEntry)
+	entry.index = len(*lh)
+	*lh = append(*lh, entry)
+}
+
+func (lh *lfuHeap) Pop() interface{} {
+	old := *lh
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*lh = old[:len(old)-1]
+	return entry
+}
+
+// lfuEvictor 是 LFU 淘汰策略。
+type lfuEvictor struct {
+
+	// entries 记录着 key 对应的数据。
+	entries map[string]*lfuEntry
+
+	// heap 是所有数据组成的小顶堆。
+	heap lfuHeap
+
+	// tick 是逻辑时间，每次访问都会加 1。
+	tick uint64
+
+	// lock 用于保证这个淘汰策略的并发安全。
+	lock *sync.Mutex
+}
+
+// newLFUEvictor 返回一个 LFU 淘汰策略实例。
+func newLFUEvictor() *lfuEvictor {
+	return &lfuEvictor{
+		entries: map[string]*lfuEntry{},
+		heap:    lfuHeap{},
+		lock:    &sync.Mutex{},
+	}
+}
+
+func (le *lfuEvictor) add(key string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if _, ok := le.entries[key]; ok {
+		le.touch(key)
+		return
+	}
+
+	le.tick++
+	entry := &lfuEntry{key: key, count: 1, tick: le.tick}
+	le.entries[key] = entry
+	heap.Push(&le.heap, entry)
+}
+
+func (le *lfuEvictor) access(key string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	le.touch(key)
+}
+
+// touch 增加 key 的访问次数，调用之前需要先加锁。
+func (le *lfuEvictor) touch(key string) {
+	if entry, ok := le.entries[key]; ok {
+		le.tick++
+		entry.count++
+		entry.tick = le.tick
+		heap.Fix(&le.heap, entry.index)
+	}
+}
+
+func (le *lfuEvictor) remove(key string) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if entry, ok := le.entries[key]; ok {
+		heap.Remove(&le.heap, entry.index)
+		delete(le.entries, key)
+	}
+}
+
+func (le *lfuEvictor) victim(candidate string) (string, bool) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	if len(le.heap) <= 0 {
+		return "", false
+	}
+
+	if le.heap[0].key != candidate {
+		return le.heap[0].key, true
+	}
+
+	// 堆顶是 candidate 的话，次小的数据一定是堆顶的某个子节点
+	victim := -1
+	for i := 1; i <= 2 && i < len(le.heap); i++ {
+		if victim < 0 || le.heap.Less(i, victim) {
+			victim = i
+		}
+	}
+
+	if victim < 0 {
+		return "", false
+	}
+	return le.heap[victim].key, true
+}
+
+// =======================================================================
+
+const (
+	// sketchDepth 是 Count-Min Sketch 的行数。
+	sketchDepth = 4
+
+	// sketchWidth 是 Count-Min Sketch 每一行的计数器个数，必须是 2 的次幂。
+	sketchWidth = 512
+
+	// sketchResetSize 是计数器衰减的周期，每记录这么多次访问就会把所有计数器减半，让旧的热点数据逐渐冷却。
+	sketchResetSize = 10 * sketchWidth
+
+	// windowPercent 是 W-TinyLFU 中窗口区占所有数据的百分比。
+	windowPercent = 1
+)
+
+// sketchSeeds 是 Count-Min Sketch 每一行使用的哈希种子。
+var sketchSeeds = [sketchDepth]uint32{0x9747b28c, 0xc2b2ae35, 0x27d4eb2f, 0x165667b1}
+
+// countMinSketch 是用于估算访问频率的 Count-Min Sketch。
+type countMinSketch struct {
+
+	// counters 存储着所有的计数器。
+	counters [sketchDepth][sketchWidth]uint8
+
+	// additions 记录着距离上次衰减之后记录了多少次访问。
+	additions int
+}
+
+// hashOf 使用 FNV-1a 算法计算 key 在第 row 行的哈希值。
+func (cms *countMinSketch) hashOf(key string, row int) uint32 {
+	hash := uint32(2166136261) ^ sketchSeeds[row]
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash & (sketchWidth - 1)
+}
+
+// increment 记录一次 key 的访问。
+func (cms *countMinSketch) increment(key string) {
+	for row := 0; row < sketchDepth; row++ {
+		column := cms.hashOf(key, row)
+		if cms.counters[row][column] < 255 {
+			cms.counters[row][column]++
+		}
+	}
+
+	cms.additions++
+	if cms.additions >= sketchResetSize {
+		cms.reset()
+	}
+}
+
+// frequency 返回 key 的估算访问频率，也就是所有行中最小的计数。
+func (cms *countMinSketch) frequency(key string) uint8 {
+	result := uint8(255)
+	for row := 0; row < sketchDepth; row++ {
+		if count := cms.counters[row][cms.hashOf(key, row)]; count < result {
+			result = count
+		}
+	}
+	return result
+}
+
+// reset 把所有计数器减半。
+func (cms *countMinSketch) reset() {
+	for row := 0; row < sketchDepth; row++ {
+		for column := 0; column < sketchWidth; column++ {
+			cms.counters[row][column] >>= 1
+		}
+	}
+	cms.additions = 0
+}
+
+// tinyLFUEvictor 是带准入过滤的 W-TinyLFU 淘汰策略。
+// 新数据会先进入一个很小的 LRU 窗口区，窗口区满了之后，窗口区淘汰出来的数据需要和主区的淘汰数据比较访问频率，
+// 只有频率更高的数据才能进入主区，这样可以避免偶发的大量冷数据把主区的热点数据挤出去。
+type tinyLFUEvictor struct {
+
+	// sketch 用于估算数据的访问频率。
+	sketch *countMinSketch
+
+	// elements 记录着 key 在窗口区或者主区链表中的位置。
+	elements map[string]*list.Element
+
+	// inWindow 记录着 key 是否在窗口区中。
+	inWindow map[string]bool
+
+	// window 是窗口区的 LRU 链表。
+	window *list.List
+
+	// main 是主区的 LRU 链表。
+	main *list.List
+
+	// lock 用于保证这个淘汰策略的并发安全。
+	lock *sync.Mutex
+}
+
+// newTinyLFUEvictor 返回一个 W-TinyLFU 淘汰策略实例。
+func newTinyLFUEvictor() *tinyLFUEvictor {
+	return &tinyLFUEvictor{
+		sketch:   &countMinSketch{},
+		elements: map[string]*list.Element{},
+		inWindow: map[string]bool{},
+		window:   list.New(),
+		main:     list.New(),
+		lock:     &sync.Mutex{},
+	}
+}
+
+func (tle *tinyLFUEvictor) add(key string) {
+	tle.lock.Lock()
+	defer tle.lock.Unlock()
+	tle.sketch.increment(key)
+	if _, ok := tle.elements[key]; ok {
+		tle.touch(key)
+		return
+	}
+
+	tle.elements[key] = tle.window.PushFront(key)
+	tle.inWindow[key] = true
+
+	// 还没有写满的时候不需要淘汰数据，窗口区超过配额的数据直接进入主区
+	for tle.window.Len() > tle.windowLimit() {
+		tle.promote(tle.window.Back().Value.(string))
+	}
+}
+
+func (tle *tinyLFUEvictor) access(key string) {
+	tle.lock.Lock()
+	defer tle.lock.Unlock()
+	tle.sketch.increment(key)
+	tle.touch(key)
+}
+
+// touch 把 key 移动到所在链表的头部，调用之前需要先加锁。
+func (tle *tinyLFUEvictor) touch(key string) {
+	if element, ok := tle.elements[key]; ok {
+		if tle.inWindow[key] {
+			tle.window.MoveToFront(element)
+		} else {
+			tle.main.MoveToFront(element)
+		}
+	}
+}
+
+func (tle *tinyLFUEvictor) remove(key string) {
+	tle.lock.Lock()
+	defer tle.lock.Unlock()
+	element, ok := tle.elements[key]
+	if !ok {
+		return
+	}
+
+	if tle.inWindow[key] {
+		tle.window.Remove(element)
+	} else {
+		tle.main.Remove(element)
+	}
+	delete(tle.elements, key)
+	delete(tle.inWindow, key)
+}
+
+func (tle *tinyLFUEvictor) victim(candidate string) (string, bool) {
+	tle.lock.Lock()
+	defer tle.lock.Unlock()
+
+	// candidate 加入之后窗口区也不会超过配额的话，直接淘汰主区中最久没有访问的数据
+	windowVictim, hasWindowVictim := lastKeyExcept(tle.window, candidate)
+	mainVictim, hasMainVictim := lastKeyExcept(tle.main, candidate)
+	if !hasWindowVictim || (hasMainVictim && tle.window.Len() < tle.windowLimit()) {
+		return mainVictim, hasMainVictim
+	}
+
+	if !hasMainVictim {
+		return windowVictim, true
+	}
+
+	// 窗口区超过配额了，窗口区淘汰出来的数据要进入主区，需要经过准入过滤：
+	// 如果它的访问频率比主区的淘汰数据高，就让它进入主区，淘汰主区的数据，否则直接淘汰它自己
+	if tle.sketch.frequency(windowVictim) > tle.sketch.frequency(mainVictim) {
+		tle.promote(windowVictim)
+		return mainVictim, true
+	}
+	return windowVictim, true
+}
+
+// windowLimit 返回窗口区最多可以存放的数据个数，调用之前需要先加锁。
+func (tle *tinyLFUEvictor) windowLimit() int {
+	limit := len(tle.elements) * windowPercent / 100
+	if limit < 1 {
+		return 1
+	}
+	return limit
+}
+
+// promote 把窗口区中的 key 移动到主区，调用之前需要先加锁。
+func (tle *tinyLFUEvictor) promote(key string) {
+	tle.window.Remove(tle.elements[key])
+	tle.elements[key] = tle.main.PushFront(key)
+	tle.inWindow[key] = false
+}
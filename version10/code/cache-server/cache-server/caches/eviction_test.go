@@ -0,0 +1,95 @@
+package caches
+
+import (
+	"testing"
+)
+
+// newEvictionTestCache 返回一个只有一个 segment，并且只能存放两个测试数据的缓存实例。
+func newEvictionTestCache(t *testing.T, policy string) *Cache {
+	options := DefaultOptions()
+	options.MaxEntrySize = 1 << 20
+	options.SegmentSize = 1
+	options.DumpFile = ""
+	options.EvictionPolicy = policy
+	cache, err := NewCacheWith(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+// go test -v -run=^TestEvictionPolicy$
+func TestEvictionPolicy(t *testing.T) {
+
+	value := make([]byte, 400*1024)
+	testCases := []struct {
+		policy  string
+		evicted string
+	}{
+		{policy: LRUEviction, evicted: "b"},
+		{policy: LFUEviction, evicted: "b"},
+		{policy: FIFOEviction, evicted: "a"},
+		{policy: TinyLFUEviction, evicted: "b"},
+	}
+
+	for _, testCase := range testCases {
+		cache := newEvictionTestCache(t, testCase.policy)
+		cache.Set("a", value)
+		cache.Set("b", value)
+		cache.Get("a")
+		cache.Get("a")
+
+		if err := cache.Set("c", value); err != nil {
+			t.Fatalf("%s: set c returns %+v", testCase.policy, err)
+		}
+
+		if _, ok := cache.Get(testCase.evicted); ok {
+			t.Fatalf("%s: %s should be evicted", testCase.policy, testCase.evicted)
+		}
+
+		if _, ok := cache.Get("c"); !ok {
+			t.Fatalf("%s: c should be in cache", testCase.policy)
+		}
+
+		if status := cache.Status(); status.Count != 2 {
+			t.Fatalf("%s: count %d is wrong", testCase.policy, status.Count)
+		}
+	}
+}
+
+// go test -v -run=^TestEvictionOversizedEntry$
+func TestEvictionOversizedEntry(t *testing.T) {
+
+	value := make([]byte, 400*1024)
+	for _, policy := range []string{LRUEviction, LFUEviction, FIFOEviction, TinyLFUEviction} {
+		cache := newEvictionTestCache(t, policy)
+		cache.Set("a", value)
+		cache.Set("b", value)
+
+		// 比整个 segment 还大的数据怎么淘汰都放不下，应该直接拒绝，而不是先把其他数据都淘汰掉
+		if err := cache.Set("c", make([]byte, 2<<20)); err != entrySizeExceededErr {
+			t.Fatalf("%s: set an oversized entry returns %+v", policy, err)
+		}
+
+		if status := cache.Status(); status.Count != 2 || status.Evicted != 0 {
+			t.Fatalf("%s: count %d or evicted %d is wrong", policy, status.Count, status.Evicted)
+		}
+	}
+}
+
+// go test -v -run=^TestNoEviction$
+func TestNoEviction(t *testing.T) {
+
+	value := make([]byte, 400*1024)
+	cache := newEvictionTestCache(t, NoEviction)
+	cache.Set("a", value)
+	cache.Set("b", value)
+
+	if err := cache.Set("c", value); err != entrySizeExceededErr {
+		t.Fatalf("set c should return %+v but got %+v", entrySizeExceededErr, err)
+	}
+
+	if err := cache.Set("a", value[:1024]); err != nil {
+		t.Fatalf("overwriting a returns %+v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package caches
+
+import (
+	"container/heap"
+)
+
+// expiryItem 是过期队列中的一项，记录着 key 和它的过期时间。
+type expiryItem struct {
+
+	// key 是数据的 key。
+	key string
+
+	// deadline 是数据的过期时间，Unix 时间戳，单位是纳秒。
+	deadline int64
+
+	// index 是这一项在堆中的下标，用于删除和调整位置。
+	index int
+}
+
+// expiryQueue 是按照过期时间排序的最小堆，用于主动清理过期的数据。
+// 只有会过期的数据才会进入这个队列，清理的时候只需要查看堆顶，不需要遍历整个 map。
+// 空闲过期的数据在读取的时候会更新访问时间，但是读取操作只持有读锁，没办法调整堆，
+// 所以队列中的过期时间可能比实际的早，清理的时候需要重新计算，还没过期的就按照新的过期时间放回去。
+type expiryQueue []*expiryItem
+
+// newExpiryQueue 返回一个空的过期队列。
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{}
+}
+
+// Len 返回队列的长度。
+func (eq expiryQueue) Len() int {
+	return len(eq)
+}
+
+// Less 判断第 i 项是否比第 j 项更早过期。
+func (eq expiryQueue) Less(i, j int) bool {
+	return eq[i].deadline < eq[j].deadline
+}
+
+// Swap 交换第 i 项和第 j 项，同时更新它们的下标。
+func (eq expiryQueue) Swap(i, j int) {
+	eq[i], eq[j] = eq[j], eq[i]
+	eq[i].index = i
+	eq[j].index = j
+}
+
+// Push 把 x 添加到队列的末尾，这个方法是给 heap 包使用的。
+func (eq *expiryQueue) Push(x interface{}) {
+	item := x.(*expiryItem)
+	item.index = len(*eq)
+	*eq = append(*eq, item)
+}
+
+// Pop 移除并返回队列末尾的一项，这个方法是给 heap 包使用的。
+func (eq *expiryQueue) Pop() interface{} {
+	old := *eq
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*eq = old[:n-1]
+	return item
+}
+
+// add 把 key 对应的数据加入到队列中，永不过期的数据不会加入。
+func (eq *expiryQueue) add(key string, v *value) {
+	deadline := v.deadline()
+	if deadline == NeverDie {
+		v.expiry = nil
+		return
+	}
+
+	v.expiry = &expiryItem{key: key, deadline: deadline}
+	heap.Push(eq, v.expiry)
+}
+
+// remove 把数据从队列中移除，不在队列中的数据什么也不做。
+func (eq *expiryQueue) remove(v *value) {
+	if v.expiry == nil {
+		return
+	}
+	heap.Remove(eq, v.expiry.index)
+	v.expiry = nil
+}
+
+// peek 返回最早过期的一项，队列为空的时候返回 nil。
+func (eq expiryQueue) peek() *expiryItem {
+	if len(eq) == 0 {
+		return nil
+	}
+	return eq[0]
+}
+
+// reschedule 把 item 的过期时间更新为 deadline，并调整它在堆中的位置。
+func (eq *expiryQueue) reschedule(item *expiryItem, deadline int64) {
+	item.deadline = deadline
+	heap.Fix(eq, item.index)
+}
@@ -0,0 +1,120 @@
+package caches
+
+import (
+	"time"
+
+	"cache-server/helpers"
+)
+
+// HSet 把 fields 中的字段设置到 key 对应的哈希中，返回新增的字段个数，已经存在的字段会被覆盖。
+// key 不存在的话会创建一个哈希，ttl 和 opts 只在创建的时候使用，已经存在的哈希会保留原来的有效期。
+// 修改字段的时候会复制出新的哈希，所以字段很多的哈希不适合频繁地修改。
+func (c *Cache) HSet(key string, fields map[string][]byte, ttl time.Duration, opts ...SetOption) (int, error) {
+	added := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		newValue, err := c.valueToModify(oldValue, hashType, ttl, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		hash := make(map[string][]byte, len(newValue.Hash)+len(fields))
+		for field, data := range newValue.Hash {
+			hash[field] = data
+		}
+
+		added = 0
+		for field, data := range fields {
+			if _, ok := hash[field]; !ok {
+				added++
+			}
+			hash[field] = helpers.Copy(data)
+		}
+
+		// 空的哈希不会被保存，否则持久化之后就变成普通的数据了
+		if len(hash) == 0 {
+			return oldValue, nil
+		}
+		newValue.Hash = hash
+		return newValue, nil
+	})
+	return added, err
+}
+
+// HGet 返回 key 对应的哈希中 field 字段的数据，第二个返回值表示字段是否存在，key 不是哈希的话返回 WrongTypeErr。
+func (c *Cache) HGet(key string, field string) ([]byte, bool, error) {
+	v, ok, err := c.hashOf(key)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+
+	data, ok := v.Hash[field]
+	return data, ok, nil
+}
+
+// HGetAll 返回 key 对应的哈希中所有的字段，key 不存在的话返回空的 map，key 不是哈希的话返回 WrongTypeErr。
+// 返回的 map 是一个副本，可以随意修改，但是字段的数据和缓存共享，不能修改。
+func (c *Cache) HGetAll(key string) (map[string][]byte, error) {
+	v, ok, err := c.hashOf(key)
+	if !ok || err != nil {
+		return map[string][]byte{}, err
+	}
+
+	hash := make(map[string][]byte, len(v.Hash))
+	for field, data := range v.Hash {
+		hash[field] = data
+	}
+	return hash, nil
+}
+
+// HDel 删除 key 对应的哈希中的 fields 字段，返回实际删除的字段个数，所有字段都被删除的话 key 也会被删除。
+func (c *Cache) HDel(key string, fields ...string) (int, error) {
+	deleted := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		if oldValue == nil {
+			return nil, nil
+		}
+
+		if oldValue.Type != hashType {
+			return nil, WrongTypeErr
+		}
+
+		hash := make(map[string][]byte, len(oldValue.Hash))
+		for field, data := range oldValue.Hash {
+			hash[field] = data
+		}
+
+		deleted = 0
+		for _, field := range fields {
+			if _, ok := hash[field]; ok {
+				delete(hash, field)
+				deleted++
+			}
+		}
+
+		if deleted == 0 {
+			return oldValue, nil
+		}
+
+		if len(hash) == 0 {
+			return nil, nil
+		}
+
+		newValue := oldValue.copyForUpdate()
+		newValue.Hash = hash
+		return newValue, nil
+	})
+	return deleted, err
+}
+
+// hashOf 返回 key 对应的哈希，第二个返回值表示 key 是否存在，key 不是哈希的话返回 WrongTypeErr。
+func (c *Cache) hashOf(key string) (*value, bool, error) {
+	v, ok := c.segmentOf(key).getValue(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if v.Type != hashType {
+		return nil, false, WrongTypeErr
+	}
+	return v, true, nil
+}
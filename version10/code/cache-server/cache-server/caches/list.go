@@ -0,0 +1,234 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cache-server/helpers"
+)
+
+var (
+	// cacheClosedErr 是缓存已经关闭，阻塞的操作不再等待时返回的错误。
+	cacheClosedErr = errors.New("the cache is closed")
+)
+
+// LPush 把 values 依次插入到 key 对应的列表头部，返回插入之后列表的长度，所以最后一个值会在最前面。
+// key 不存在的话会创建一个列表，ttl 和 opts 只在创建的时候使用，已经存在的列表会保留原来的有效期。
+// 插入头部需要复制整个列表，用作队列的时候建议使用 RPush 和 LPop。
+func (c *Cache) LPush(key string, values [][]byte, ttl time.Duration, opts ...SetOption) (int, error) {
+	return c.push(key, values, true, ttl, opts)
+}
+
+// RPush 把 values 依次追加到 key 对应的列表尾部，返回追加之后列表的长度，其他和 LPush 一样。
+func (c *Cache) RPush(key string, values [][]byte, ttl time.Duration, opts ...SetOption) (int, error) {
+	return c.push(key, values, false, ttl, opts)
+}
+
+// push 把 values 插入到 key 对应的列表中，left 为 true 表示插入到头部，返回插入之后列表的长度。
+func (c *Cache) push(key string, values [][]byte, left bool, ttl time.Duration, opts []SetOption) (int, error) {
+	length := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		newValue, err := c.valueToModify(oldValue, listType, ttl, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// 空的列表不会被保存，否则持久化之后就变成普通的数据了
+		length = len(newValue.List)
+		if len(values) == 0 {
+			return oldValue, nil
+		}
+
+		if left {
+			list := make([][]byte, 0, len(values)+len(newValue.List))
+			for i := len(values) - 1; i >= 0; i-- {
+				list = append(list, helpers.Copy(values[i]))
+			}
+			newValue.List = append(list, newValue.List...)
+		} else {
+			// 追加只会写入旧列表长度之后的位置，旧的数据看不到这些位置，所以可以和旧的数据共享底层数组
+			for _, data := range values {
+				newValue.List = append(newValue.List, helpers.Copy(data))
+			}
+		}
+
+		length = len(newValue.List)
+		return newValue, nil
+	})
+	return length, err
+}
+
+// LPop 弹出 key 对应的列表头部的元素，第二个返回值表示是否弹出了元素，弹出最后一个元素之后 key 也会被删除。
+func (c *Cache) LPop(key string) ([]byte, bool, error) {
+	data, ok, _, err := c.pop(key, true, false)
+	return data, ok, err
+}
+
+// RPop 弹出 key 对应的列表尾部的元素，其他和 LPop 一样。
+func (c *Cache) RPop(key string) ([]byte, bool, error) {
+	data, ok, _, err := c.pop(key, false, false)
+	return data, ok, err
+}
+
+// BLPop 弹出 key 对应的列表头部的元素，列表为空的话会一直等待，直到有新的元素或者 ctx 结束。
+// ctx 结束的话返回 ctx 的错误，比如超时就是 context.DeadlineExceeded，缓存关闭的话也会马上返回。
+// 同时有多个等待者的话，新元素到来时会唤醒所有的等待者，只有一个能弹出元素，其他的继续等待。
+func (c *Cache) BLPop(ctx context.Context, key string) ([]byte, error) {
+	return c.blockingPop(ctx, key, true)
+}
+
+// BRPop 弹出 key 对应的列表尾部的元素，其他和 BLPop 一样。
+func (c *Cache) BRPop(ctx context.Context, key string) ([]byte, error) {
+	return c.blockingPop(ctx, key, false)
+}
+
+// blockingPop 弹出 key 对应的列表中的元素，列表为空的话会等待，直到有新的元素或者 ctx 结束。
+func (c *Cache) blockingPop(ctx context.Context, key string, left bool) ([]byte, error) {
+	for {
+		data, ok, wait, err := c.pop(key, left, true)
+		if ok || err != nil {
+			return data, err
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			c.segmentOf(key).removeWaiter(key, wait)
+			return nil, ctx.Err()
+		case <-c.closeCh:
+			c.segmentOf(key).removeWaiter(key, wait)
+			return nil, cacheClosedErr
+		}
+	}
+}
+
+// pop 弹出 key 对应的列表中的元素，left 为 true 表示从头部弹出。
+// 列表为空并且 wait 为 true 的话，会在同一个写锁中注册一个等待者，这样在检查和等待之间插入的元素也不会错过。
+func (c *Cache) pop(key string, left bool, wait bool) ([]byte, bool, chan struct{}, error) {
+	var data []byte
+	var popped bool
+	var waiter chan struct{}
+	segment := c.segmentOf(key)
+	err := segment.modify(key, func(oldValue *value) (*value, error) {
+		if oldValue == nil {
+			if wait {
+				waiter = segment.addWaiter(key)
+			}
+			return nil, nil
+		}
+
+		if oldValue.Type != listType {
+			return nil, WrongTypeErr
+		}
+
+		list := oldValue.List
+		popped = true
+		if left {
+			data, list = list[0], list[1:]
+		} else {
+			// 限制容量之后再追加就会复制出新的底层数组，不会覆盖旧的数据看得到的最后一个位置
+			data, list = list[len(list)-1], list[:len(list)-1:len(list)-1]
+		}
+
+		if len(list) == 0 {
+			return nil, nil
+		}
+
+		newValue := oldValue.copyForUpdate()
+		newValue.List = list
+		return newValue, nil
+	})
+
+	if err != nil {
+		return nil, false, nil, err
+	}
+	return data, popped, waiter, nil
+}
+
+// LRange 返回 key 对应的列表中从 start 到 stop 的元素，包括 stop，key 不存在的话返回空的列表。
+// 负数的下标表示从尾部开始数，比如 -1 是最后一个元素，所以 LRange(key, 0, -1) 返回所有的元素。
+func (c *Cache) LRange(key string, start int, stop int) ([][]byte, error) {
+	v, ok, err := c.listOf(key)
+	if !ok || err != nil {
+		return [][]byte{}, err
+	}
+
+	start, stop = listRangeOf(len(v.List), start, stop)
+	list := make([][]byte, stop-start)
+	copy(list, v.List[start:stop])
+	return list, nil
+}
+
+// LTrim 只保留 key 对应的列表中从 start 到 stop 的元素，下标的规则和 LRange 一样，没有元素保留下来的话 key 也会被删除。
+func (c *Cache) LTrim(key string, start int, stop int) error {
+	return c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		if oldValue == nil {
+			return nil, nil
+		}
+
+		if oldValue.Type != listType {
+			return nil, WrongTypeErr
+		}
+
+		start, stop := listRangeOf(len(oldValue.List), start, stop)
+		if start == 0 && stop == len(oldValue.List) {
+			return oldValue, nil
+		}
+
+		if start == stop {
+			return nil, nil
+		}
+
+		newValue := oldValue.copyForUpdate()
+		newValue.List = oldValue.List[start:stop:stop]
+		return newValue, nil
+	})
+}
+
+// LLen 返回 key 对应的列表的长度，key 不存在的话返回 0。
+func (c *Cache) LLen(key string) (int, error) {
+	v, ok, err := c.listOf(key)
+	if !ok || err != nil {
+		return 0, err
+	}
+	return len(v.List), nil
+}
+
+// listOf 返回 key 对应的列表，第二个返回值表示 key 是否存在，key 不是列表的话返回 WrongTypeErr。
+func (c *Cache) listOf(key string) (*value, bool, error) {
+	v, ok := c.segmentOf(key).getValue(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if v.Type != listType {
+		return nil, false, WrongTypeErr
+	}
+	return v, true, nil
+}
+
+// listRangeOf 把包括 stop 的下标范围转换成长度为 length 的列表中左闭右开的范围，负数的下标表示从尾部开始数。
+// 超出列表的部分会被截掉，范围为空的话返回的 start 和 stop 相等。
+func listRangeOf(length int, start int, stop int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	if start > stop {
+		return 0, 0
+	}
+	return start, stop + 1
+}
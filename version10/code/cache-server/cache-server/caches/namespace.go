@@ -0,0 +1,331 @@
+package caches
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	// namespaceFileSuffix 是命名空间的持久化文件和 AOF 文件相对于默认命名空间的后缀，后面跟着命名空间的名字。
+	namespaceFileSuffix = ".ns."
+
+	// namespacesFileSuffix 是记录所有命名空间配置的文件相对于持久化文件的后缀。
+	namespacesFileSuffix = ".namespaces"
+
+	// namespaceSegmentEntrySize 是没有指定 segment 数量的命名空间中每个 segment 至少分到的容量，单位是字节。
+	namespaceSegmentEntrySize = 64 << 10
+)
+
+var (
+	// NamespaceNotFoundErr 是命名空间不存在时返回的错误。
+	NamespaceNotFoundErr = errors.New("the namespace is not found")
+
+	// NamespaceExistsErr 是创建已经存在的命名空间时返回的错误。
+	NamespaceExistsErr = errors.New("the namespace already exists")
+
+	// InvalidNamespaceErr 是命名空间的名字或者配置不合法时返回的错误。
+	InvalidNamespaceErr = errors.New("the namespace name should only contain letters, digits, - and _, and segment size should be a power of 2")
+
+	// InvalidNamespaceQuotaErr 是命名空间的容量上限不合法时返回的错误。
+	// 容量上限必须设置，不能超过默认命名空间的容量上限，并且平均分给每个 segment 之后至少要能放下一个键值对。
+	InvalidNamespaceQuotaErr = errors.New("the namespace should have a max entry size no more than the root cache, and large enough for every segment to hold an entry")
+
+	// NamespaceQuotaExceededErr 是所有命名空间的容量上限加起来超过默认命名空间的容量上限时返回的错误。
+	NamespaceQuotaExceededErr = errors.New("the max entry size of all namespaces will exceed the root cache if you create this namespace")
+
+	// nestedNamespaceErr 是在命名空间中再创建命名空间时返回的错误。
+	nestedNamespaceErr = errors.New("namespaces can not be nested")
+
+	// namespaceNamePattern 是命名空间名字的格式，因为名字会作为文件名的一部分，所以只允许字母、数字、- 和 _。
+	namespaceNamePattern = regexp.MustCompile("^[A-Za-z0-9_-]{1,64}$")
+)
+
+// NamespaceOptions 是命名空间的选项配置，没有设置的配置会使用默认命名空间的配置。
+type NamespaceOptions struct {
+
+	// MaxEntrySize 是这个命名空间的容量上限，单位是字节，写满之后只会影响这个命名空间。
+	// 这个配置是必须的，所有命名空间的容量上限加起来也不能超过默认命名空间的容量上限，否则一个或者几个命名空间就可以占满所有的内存。
+	MaxEntrySize int64 `json:"maxEntrySize"`
+
+	// DefaultTTL 是这个命名空间中添加数据时没有指定有效期的情况下使用的有效期，NeverDie 表示永不过期。
+	// 设置了默认有效期之后，还可以使用 Forever 作为有效期添加永不过期的数据。
+	DefaultTTL time.Duration `json:"defaultTtl"`
+
+	// EvictionPolicy 是这个命名空间写满之后使用的淘汰策略。
+	EvictionPolicy string `json:"evictionPolicy"`
+
+	// SegmentSize 是这个命名空间的 segment 数量，需要是 2 的幂，容量比较小的命名空间可以使用更少的 segment。
+	// 没有设置的话会在默认命名空间的 segment 数量的基础上减少，直到每个 segment 至少有 64 KB 的容量或者只剩一个 segment。
+	SegmentSize int `json:"segmentSize"`
+}
+
+// namespaceOptionsOf 返回命名空间 name 使用的缓存配置，持久化文件和 AOF 文件都是在默认命名空间的文件名后面加上命名空间的名字。
+func (c *Cache) namespaceOptionsOf(name string, nsOptions NamespaceOptions) Options {
+	options := *c.options
+	options.RecoverSnapshot = ""
+	options.DefaultTTL = nsOptions.DefaultTTL
+	if options.DumpFile != "" {
+		options.DumpFile += namespaceFileSuffix + name
+	}
+
+	if options.AofFile != "" {
+		options.AofFile += namespaceFileSuffix + name
+	}
+
+	if nsOptions.MaxEntrySize > 0 {
+		options.MaxEntrySize = nsOptions.MaxEntrySize
+	}
+
+	if nsOptions.EvictionPolicy != "" {
+		options.EvictionPolicy = nsOptions.EvictionPolicy
+	}
+
+	// 容量是平均分给每个 segment 的，容量比较小的命名空间使用默认的 segment 数量的话，每个 segment 可能连一个键值对都放不下
+	if nsOptions.SegmentSize > 0 {
+		options.SegmentSize = nsOptions.SegmentSize
+	} else {
+		for options.SegmentSize > 1 && options.MaxEntrySize/int64(options.SegmentSize) < namespaceSegmentEntrySize {
+			options.SegmentSize /= 2
+		}
+	}
+	return options
+}
+
+// checkNamespace 检查命名空间的名字和配置是否合法。
+func (c *Cache) checkNamespace(name string, nsOptions NamespaceOptions) error {
+	if !namespaceNamePattern.MatchString(name) || nsOptions.SegmentSize&(nsOptions.SegmentSize-1) != 0 {
+		return InvalidNamespaceErr
+	}
+
+	if nsOptions.MaxEntrySize <= 0 || nsOptions.MaxEntrySize > c.options.MaxEntrySize {
+		return InvalidNamespaceQuotaErr
+	}
+
+	// 指定了 segment 数量的话，每个 segment 分到的容量至少要能放下一个最小的键值对
+	if nsOptions.SegmentSize > 0 && nsOptions.MaxEntrySize/int64(nsOptions.SegmentSize) < entryOverhead {
+		return InvalidNamespaceQuotaErr
+	}
+	return checkEvictionPolicy(nsOptions.EvictionPolicy)
+}
+
+// Namespace 返回名字为 name 的命名空间，第二个返回值表示这个命名空间是否存在。
+// 命名空间也是一个 Cache，拥有自己的数据、容量上限、淘汰策略、默认有效期和统计数据，持久化和 AOF 也都是独立的文件。
+func (c *Cache) Namespace(name string) (*Cache, bool) {
+	if c.namespaces == nil {
+		return nil, false
+	}
+
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+	namespace, ok := c.namespaces[name]
+	return namespace, ok
+}
+
+// Namespaces 返回所有命名空间的配置。
+func (c *Cache) Namespaces() map[string]NamespaceOptions {
+	if c.namespaces == nil {
+		return map[string]NamespaceOptions{}
+	}
+
+	c.namespaceLock.RLock()
+	defer c.namespaceLock.RUnlock()
+	result := make(map[string]NamespaceOptions, len(c.namespaceOptions))
+	for name, nsOptions := range c.namespaceOptions {
+		result[name] = nsOptions
+	}
+	return result
+}
+
+// CreateNamespace 使用 nsOptions 创建一个名字为 name 的命名空间，命名空间已经存在的话返回 NamespaceExistsErr。
+// 命名空间的配置会记录到持久化文件旁边的文件中，重启的时候会自动恢复所有的命名空间和它们的数据。
+func (c *Cache) CreateNamespace(name string, nsOptions NamespaceOptions) (*Cache, error) {
+	if c.namespaces == nil {
+		return nil, nestedNamespaceErr
+	}
+
+	if err := c.checkNamespace(name, nsOptions); err != nil {
+		return nil, err
+	}
+
+	c.namespaceLock.Lock()
+	defer c.namespaceLock.Unlock()
+	if _, ok := c.namespaces[name]; ok {
+		return nil, NamespaceExistsErr
+	}
+
+	if c.allocatedQuota()+nsOptions.MaxEntrySize > c.options.MaxEntrySize {
+		return nil, NamespaceQuotaExceededErr
+	}
+
+	namespace, err := newCacheWith(c.namespaceOptionsOf(name, nsOptions))
+	if err != nil {
+		return nil, err
+	}
+
+	c.namespaces[name] = namespace
+	c.namespaceOptions[name] = nsOptions
+	if err = c.saveNamespaces(); err != nil {
+		delete(c.namespaces, name)
+		delete(c.namespaceOptions, name)
+		namespace.drop()
+		return nil, err
+	}
+	return namespace, nil
+}
+
+// allocatedQuota 返回已经分配给所有命名空间的容量上限之和，调用之前需要先加锁。
+// 之前没有设置容量上限就创建的命名空间使用的是默认命名空间的容量上限，没办法算进去，所以这里会忽略它们。
+func (c *Cache) allocatedQuota() int64 {
+	allocated := int64(0)
+	for _, nsOptions := range c.namespaceOptions {
+		allocated += nsOptions.MaxEntrySize
+	}
+	return allocated
+}
+
+// DropNamespace 删除名字为 name 的命名空间，它的数据、持久化文件和 AOF 文件都会被删除，命名空间不存在的话返回 NamespaceNotFoundErr。
+func (c *Cache) DropNamespace(name string) error {
+	if c.namespaces == nil {
+		return NamespaceNotFoundErr
+	}
+
+	c.namespaceLock.Lock()
+	defer c.namespaceLock.Unlock()
+	namespace, ok := c.namespaces[name]
+	if !ok {
+		return NamespaceNotFoundErr
+	}
+
+	delete(c.namespaces, name)
+	delete(c.namespaceOptions, name)
+	if err := c.saveNamespaces(); err != nil {
+		return err
+	}
+	return namespace.drop()
+}
+
+// eachNamespace 对所有的命名空间执行 fn，遇到错误就马上返回。
+// 执行 fn 的时候不会持有命名空间的锁，所以 fn 中可以做持久化这些比较耗时的操作。
+func (c *Cache) eachNamespace(fn func(namespace *Cache) error) error {
+	if c.namespaces == nil {
+		return nil
+	}
+
+	c.namespaceLock.RLock()
+	namespaces := make([]*Cache, 0, len(c.namespaces))
+	for _, namespace := range c.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	c.namespaceLock.RUnlock()
+
+	for _, namespace := range namespaces {
+		if err := fn(namespace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// namespacesFile 返回记录所有命名空间配置的文件，没有配置持久化文件的话返回空字符串，这时候命名空间不会被记录下来。
+func (c *Cache) namespacesFile() string {
+	if c.options.DumpFile == "" {
+		return ""
+	}
+	return c.options.DumpFile + namespacesFileSuffix
+}
+
+// saveNamespaces 把所有命名空间的配置写入到文件中，调用之前需要先加写锁。
+// 先写入临时文件再重命名，保证这个文件要么是旧的内容，要么是新的内容。
+func (c *Cache) saveNamespaces() error {
+	namespacesFile := c.namespacesFile()
+	if namespacesFile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(c.namespaceOptions)
+	if err != nil {
+		return err
+	}
+
+	if err = ioutil.WriteFile(namespacesFile+tempFileSuffix, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(namespacesFile+tempFileSuffix, namespacesFile)
+}
+
+// loadNamespaces 读取所有命名空间的配置，并从各自的持久化文件和 AOF 文件中恢复命名空间。
+func (c *Cache) loadNamespaces() error {
+	namespacesFile := c.namespacesFile()
+	if namespacesFile == "" {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(namespacesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, &c.namespaceOptions); err != nil {
+		return err
+	}
+
+	// 按照名字的顺序恢复，这样出错的时候每次都是同一个命名空间先出错，方便排查
+	names := make([]string, 0, len(c.namespaceOptions))
+	for name := range c.namespaceOptions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// 恢复的时候不再检查配置，这样之前没有设置容量上限的命名空间也能恢复，只是会继续使用默认命名空间的容量上限
+	for _, name := range names {
+		namespace, err := newCacheWith(c.namespaceOptionsOf(name, c.namespaceOptions[name]))
+		if err != nil {
+			return err
+		}
+		c.namespaces[name] = namespace
+	}
+	return nil
+}
+
+// drop 关闭这个命名空间，并删除它的持久化文件、历史快照和 AOF 文件。
+// 持有持久化的锁进行删除，并且标记为已删除，这样正在进行或者之后的持久化都不会再把文件写回来。
+func (c *Cache) drop() error {
+	c.dumpLock.Lock()
+	defer c.dumpLock.Unlock()
+	c.dropped = true
+	c.closeOnce.Do(func() {
+		close(c.closeCh)
+		if c.aof != nil {
+			c.aof.close()
+		}
+	})
+
+	var files []string
+	if c.options.DumpFile != "" {
+		snapshots, err := dumpSnapshots(c.options.DumpFile)
+		if err != nil {
+			return err
+		}
+		files = append(snapshots, c.options.DumpFile)
+	}
+
+	if c.options.AofFile != "" {
+		files = append(files, c.options.AofFile, c.options.AofFile+rewritingAofSuffix)
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,18 +1,26 @@
 package caches
 
+import "time"
+
 // Options 是选项配置结构体。
 type Options struct {
 
     // MaxEntrySize 指键值对最大容量。
-	MaxEntrySize int
+	// 单位是字节，这个容量包含了每个键值对的额外开销，具体可以看 Status 的 MemorySize。
+	MaxEntrySize int64
 
-	// MaxGcCount 指每个 segment 要清理的过期数据个数。
+	// MaxGcCount 指每次给 segment 加锁之后最多处理的过期数据个数。
+	// Gc 工作处理完一批之后会释放锁再继续处理，主动过期每次只处理一批，所以这个值越小，对读写操作的影响就越小。
 	MaxGcCount int
 
-	// GcDuration 指多久执行一次 Gc 工作。
+	// GcDuration 指多久执行一次 Gc 工作，每次都会清理掉所有已经过期的数据。
 	// 单位是分钟。
 	GcDuration int
 
+	// ExpiryInterval 指多久执行一次主动过期，每次每个 segment 最多清理 MaxGcCount 个已经到期的数据。
+	// 单位是毫秒，小于等于 0 表示不主动过期，过期的数据只会在访问的时候或者 Gc 工作中被清理。
+	ExpiryInterval int
+
 	// DumpFile 指持久化文件的路径。
 	DumpFile string
 
@@ -20,27 +28,109 @@ type Options struct {
 	// 单位是分钟。
 	DumpDuration int
 
+	// DumpCompression 指持久化文件使用的压缩算法，可选 none、gzip、zstd 和 snappy。
+	// 恢复的时候会自动识别压缩算法，所以修改这个配置不影响之前的持久化文件。
+	DumpCompression string
+
+	// DumpRetention 指保留最近的多少个历史快照，历史快照的文件名是 DumpFile 加上持久化时的时间戳。
+	// 0 表示不保留历史快照，只保留最新的 DumpFile。
+	DumpRetention int
+
+	// RecoverSnapshot 指启动时使用哪个历史快照进行恢复，可以是快照文件的路径，也可以是快照文件名中的时间戳。
+	// 为空表示使用 DumpFile 进行恢复。使用历史快照恢复的时候不会回放 AOF，因为 AOF 中的操作都发生在这个快照之后。
+	RecoverSnapshot string
+
 	// MapSizeOfSegment 指 segment 中 map 的初始化大小。
 	MapSizeOfSegment int
 
 	// SegmentSize 指缓存中有多少个 segment。
 	SegmentSize int
 
-	// CasSleepTime 指每一次 CAS 自旋需要等待的时间。
-	// 单位是微秒。
-	CasSleepTime int
+	// AofFile 指 AOF 文件的路径，为空表示不开启 AOF。
+	// 开启之后所有的写操作都会追加到这个文件中，重启的时候会在持久化文件的基础上回放这些操作。
+	AofFile string
+
+	// AofFsync 指 AOF 的刷盘策略，可选 always、everysec 和 no。
+	AofFsync string
+
+	// AofRewriteSize 指 AOF 文件达到多大的时候提前持久化并重写 AOF。
+	// 单位是字节，小于等于 0 表示只在定时持久化的时候重写。
+	AofRewriteSize int64
+
+	// EvictionPolicy 指缓存写满之后使用的淘汰策略，可选 none、lru、lfu、fifo 和 tinylfu。
+	// 使用 none 的时候不会淘汰数据，而是直接拒绝新的写入。
+	EvictionPolicy string
+
+	// DefaultTTL 指添加数据时没有指定有效期的情况下使用的有效期，NeverDie 表示永不过期。
+	DefaultTTL time.Duration
 }
 
 // DefaultOptions 返回默认的选项配置。
 func DefaultOptions() Options {
 	return Options{
-		MaxEntrySize:     4, // 4 GB
+		MaxEntrySize:     4 << 30, // 4 GB
 		MaxGcCount:       10,
-		GcDuration:       60, // 1 hour
+		GcDuration:       60,  // 1 hour
+		ExpiryInterval:   100, // 100 milliseconds
 		DumpFile:         "kafo.dump",
 		DumpDuration:     30, // 30 minutes
+		DumpCompression:  DumpCompressionNone,
+		DumpRetention:    0,
+		RecoverSnapshot:  "",
 		MapSizeOfSegment: 256,
 		SegmentSize:      1024,
-		CasSleepTime:     1000, // 1 ms
+		AofFile:          "",
+		AofFsync:         AofFsyncEverySecond,
+		AofRewriteSize:   64 << 20, // 64 MB
+		EvictionPolicy:   NoEviction,
+		DefaultTTL:       NeverDie,
+	}
+}
+
+// SetOptions 是添加数据时的可选配置。
+type SetOptions struct {
+
+	// Sliding 表示 ttl 是空闲时间，数据超过 ttl 没有被访问才会过期，每次访问都会重新计算。
+	// 默认是 false，表示 ttl 是从创建开始计算的绝对寿命，不管访问多少次都会按时过期。
+	Sliding bool
+
+	// MaxAge 是数据从创建开始的最长寿命，0 表示没有限制。
+	// 一般和 Sliding 一起使用，保证经常被访问的数据最终也会过期。
+	MaxAge time.Duration
+
+	// Tags 是数据的标签，可以使用 DeleteByTag 批量删除带有某个标签的数据。
+	Tags []string
+}
+
+// SetOption 是用于修改 SetOptions 的函数。
+type SetOption func(options *SetOptions)
+
+// Sliding 返回使用空闲过期的配置，ttl 会被当作空闲时间。
+func Sliding() SetOption {
+	return func(options *SetOptions) {
+		options.Sliding = true
+	}
+}
+
+// WithMaxAge 返回设置最长寿命的配置。
+func WithMaxAge(maxAge time.Duration) SetOption {
+	return func(options *SetOptions) {
+		options.MaxAge = maxAge
+	}
+}
+
+// WithTags 返回给数据加上标签的配置，多次使用的话标签会累加。
+func WithTags(tags ...string) SetOption {
+	return func(options *SetOptions) {
+		options.Tags = append(options.Tags, tags...)
+	}
+}
+
+// NewSetOptions 返回应用了 opts 之后的添加数据配置。
+func NewSetOptions(opts ...SetOption) SetOptions {
+	options := SetOptions{}
+	for _, opt := range opts {
+		opt(&options)
 	}
+	return options
 }
@@ -2,7 +2,35 @@ package caches
 
 import (
 	"errors"
+	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// entrySizeExceededErr 是缓存写满之后，淘汰策略也腾不出空间时返回的错误。
+	entrySizeExceededErr = errors.New("the entry size will exceed if you set this entry")
+
+	// NotIntegerErr 是对不是整数的数据进行加减时返回的错误。
+	NotIntegerErr = errors.New("the value is not an integer")
+
+	// IntegerOverflowErr 是加减之后的结果超出 int64 范围时返回的错误。
+	IntegerOverflowErr = errors.New("the integer will overflow if you increase it")
+
+	// VersionMismatchErr 是 CAS 操作时数据的版本号和期望的版本号不一致时返回的错误，数据不存在也算不一致。
+	VersionMismatchErr = errors.New("the version of the entry mismatches")
+
+	// KeyExistsErr 是只在数据不存在时才添加，但是数据已经存在时返回的错误。
+	KeyExistsErr = errors.New("the key already exists")
+
+	// KeyNotFoundErr 是只在数据存在时才添加，但是数据不存在时返回的错误。
+	KeyNotFoundErr = errors.New("the key is not found")
+
+	// WrongTypeErr 是对其他类型的数据进行操作时返回的错误，比如对普通的数据使用哈希类型的操作。
+	WrongTypeErr = errors.New("the operation is against a key holding the wrong type of value")
 )
 
 // segment 就是数据块结构体。
@@ -18,6 +46,24 @@ type segment struct {
 	// options 是缓存的选项设置。
 	options *Options
 
+	// evictor 是这个数据块使用的淘汰策略。
+	evictor evictor
+
+	// expiry 是这个数据块的过期队列，用于主动清理过期的数据。
+	expiry *expiryQueue
+
+	// tags 是这个数据块的标签索引，用于按照标签批量删除数据。
+	tags tagIndex
+
+	// aof 是记录写操作的日志文件，没有开启 AOF 的时候为空。
+	aof *appendOnlyFile
+
+	// version 是这个数据块最后分配出去的版本号，需要在写锁中修改。
+	version uint64
+
+	// waiters 存储着等待列表中出现新元素的阻塞操作，key 被设置的时候会唤醒这个 key 的所有等待者，需要在写锁中修改。
+	waiters map[string][]chan struct{}
+
 	// lock 用于保证这个数据块的并发安全。
 	lock *sync.RWMutex
 }
@@ -29,85 +75,481 @@ func newSegment(options *Options) *segment {
 		Data:    make(map[string]*value, options.MapSizeOfSegment),
 		Status:  NewStatus(),
 		options: options,
+		evictor: newEvictor(options.EvictionPolicy),
+		expiry:  newExpiryQueue(),
+		tags:    newTagIndex(),
+		waiters: map[string][]chan struct{}{},
+		// 版本号从当前时间开始分配，这样重启之后分配的版本号也会比之前的大，不会和已经删除的数据的版本号重复
+		version: uint64(time.Now().UnixNano()),
 		lock:    &sync.RWMutex{},
 	}
 }
 
-// get 返回指定 key 的数据。
+// get 返回指定 key 的数据和它的版本号。
 // 这个方法和原来 cache 的方法一样，只是移动到 segment 这里。
-func (s *segment) get(key string) ([]byte, bool) {
+// 只有普通的数据才能直接获取，其他类型的数据需要使用对应类型的操作，所以这里当作不存在。
+func (s *segment) get(key string) ([]byte, uint64, bool) {
+	value, ok := s.getValue(key)
+	if !ok || value.Type != stringType {
+		return nil, 0, false
+	}
+	return value.Data, value.Version, true
+}
+
+// getValue 返回指定 key 对应的数据，会记录命中情况和访问时间。
+// 数据设置之后就不会被修改了，所以返回之后不需要持有锁也可以读取。
+func (s *segment) getValue(key string) (*value, bool) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
+	atomic.AddInt64(&s.Status.Gets, 1)
 	value, ok := s.Data[key]
 	if !ok {
+		atomic.AddInt64(&s.Status.Misses, 1)
 		return nil, false
 	}
 
 	if !value.alive() {
+		atomic.AddInt64(&s.Status.Misses, 1)
 		s.lock.RUnlock()
-		s.delete(key)
+		s.expire(key)
 		s.lock.RLock()
 		return nil, false
 	}
-	return value.visit(), true
+	atomic.AddInt64(&s.Status.Hits, 1)
+	s.evictor.access(key)
+	value.visit()
+	return value, true
 }
 
 // set 添加一个数据进 segment。
-func (s *segment) set(key string, value []byte, ttl int64) error {
+func (s *segment) set(key string, v *value) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.setValue(key, v); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.Status.Sets, 1)
+	return nil
+}
+
+// setIf 只有在 check 返回 nil 的时候才添加数据进 segment，返回新数据的版本号。
+// check 的参数是 key 当前对应的数据，不存在或者已经过期的话是 nil，检查和添加都在写锁中完成，所以是原子的。
+func (s *segment) setIf(key string, v *value, check func(oldValue *value) error) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok := s.Data[key]
+	if ok && !oldValue.alive() {
+		oldValue = nil
+	}
+
+	if err := check(oldValue); err != nil {
+		return 0, err
+	}
+
+	if err := s.setValue(key, v); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&s.Status.Sets, 1)
+	return v.Version, nil
+}
+
+// setValue 把包装好的数据添加进 segment，调用之前需要先加写锁。
+func (s *segment) setValue(key string, v *value) error {
+
+	// 数据本身就超过了单个 segment 的上限的话，淘汰再多的数据也放不下，所以要在淘汰之前拒绝，否则会白白清空这个 segment
+	if sizeOfEntry(key, v) > s.maxEntrySize() {
+		atomic.AddInt64(&s.Status.Rejected, 1)
+		return entrySizeExceededErr
+	}
+
+	// 新数据没有版本号，需要分配一个新的，恢复的数据已经有版本号了，需要保证之后分配的版本号比它大
+	if v.Version == 0 {
+		s.version++
+		v.Version = s.version
+	} else if v.Version > s.version {
+		s.version = v.Version
+	}
+
+	oldValue, exists := s.Data[key]
+	if exists {
+		s.Status.subEntry(key, oldValue)
+	}
+
+	// 容量不够的时候交给淘汰策略去腾出空间，如果淘汰策略不允许淘汰，就触发写满保护机制
+	for !s.checkEntrySize(key, v) {
+		victim, ok := s.evictor.victim(key)
+		if !ok {
+			if exists {
+				s.Status.addEntry(key, oldValue)
+			}
+			atomic.AddInt64(&s.Status.Rejected, 1)
+			return entrySizeExceededErr
+		}
+		s.evict(victim)
+	}
+
+	// 先写日志再修改数据，如果日志写入失败，这次写入就算失败
+	if err := s.log(aofSetOp, key, v); err != nil {
+		if exists {
+			s.Status.addEntry(key, oldValue)
+		}
+		return err
+	}
+
+	if exists {
+		s.evictor.access(key)
+		s.expiry.remove(oldValue)
+		s.tags.remove(key, oldValue.Tags)
+	} else {
+		s.evictor.add(key)
+	}
+	s.expiry.add(key, v)
+	s.tags.add(key, v.Tags)
+	s.Status.addEntry(key, v)
+	s.Data[key] = v
+	s.wakeUpWaiters(key)
+	return nil
+}
+
+// addWaiter 注册一个等待 key 被设置的等待者，返回的 channel 会在 key 被设置的时候关闭，调用之前需要先加写锁。
+func (s *segment) addWaiter(key string) chan struct{} {
+	waiter := make(chan struct{})
+	s.waiters[key] = append(s.waiters[key], waiter)
+	return waiter
+}
+
+// removeWaiter 移除不再等待的等待者，比如等待超时的时候，已经被唤醒的等待者已经不在了，所以什么也不会做。
+func (s *segment) removeWaiter(key string, waiter chan struct{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	waiters := s.waiters[key]
+	for i, w := range waiters {
+		if w == waiter {
+			waiters = append(waiters[:i:i], waiters[i+1:]...)
+			break
+		}
+	}
+
+	if len(waiters) == 0 {
+		delete(s.waiters, key)
+		return
+	}
+	s.waiters[key] = waiters
+}
+
+// wakeUpWaiters 唤醒 key 的所有等待者，调用之前需要先加写锁。
+func (s *segment) wakeUpWaiters(key string) {
+	waiters, ok := s.waiters[key]
+	if !ok {
+		return
+	}
+
+	for _, waiter := range waiters {
+		close(waiter)
+	}
+	delete(s.waiters, key)
+}
+
+// update 使用 modify 修改 key 对应的数据，返回 key 是否存在，已经过期的数据也当作不存在。
+// 修改的是数据的副本，然后再重新设置进去，这样修改也会记录到 AOF 中，并且过期队列也会按照新的过期时间调整。
+// 这里只用于修改过期时间这些元信息，数据本身没有变化，所以会保留原来的版本号。
+func (s *segment) update(key string, modify func(v *value)) (bool, error) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
+	v, ok := s.Data[key]
+	if !ok || !v.alive() {
+		return false, nil
 	}
 
-	if !s.checkEntrySize(key, value) {
-		if oldValue, ok := s.Data[key]; ok {
-			s.Status.addEntry(key, oldValue.Data)
+	newValue := v.snapshot()
+	modify(newValue)
+	return true, s.setValue(key, newValue)
+}
+
+// incrBy 把 key 对应的整数加上 delta 并返回加完之后的结果，整个过程都在写锁中完成，所以是原子的。
+// key 不存在或者已经过期的话，会使用 newValue 创建一个值为 delta 的数据，这样只有创建的时候才会设置有效期。
+func (s *segment) incrBy(key string, delta int64, newValue func(data []byte) *value) (int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	v, ok := s.Data[key]
+	if !ok || !v.alive() {
+		if err := s.setValue(key, newValue([]byte(strconv.FormatInt(delta, 10)))); err != nil {
+			return 0, err
 		}
-		return errors.New("the entry size will exceed if you set this entry")
+		atomic.AddInt64(&s.Status.Sets, 1)
+		return delta, nil
+	}
+
+	if v.Type != stringType {
+		return 0, WrongTypeErr
+	}
+
+	n, err := strconv.ParseInt(string(v.Data), 10, 64)
+	if err != nil {
+		return 0, NotIntegerErr
+	}
+
+	// 两个符号相同的数相加之后符号变了，说明溢出了
+	result := n + delta
+	if (n >= 0) == (delta >= 0) && (result >= 0) != (n >= 0) {
+		return 0, IntegerOverflowErr
+	}
+
+	increased := v.copyForUpdate()
+	increased.Data = []byte(strconv.FormatInt(result, 10))
+	if err = s.setValue(key, increased); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&s.Status.Sets, 1)
+	return result, nil
+}
+
+// modify 使用 fn 修改 key 对应的数据，整个过程都在写锁中完成，所以是原子的。
+// fn 的参数是 key 当前对应的数据，不存在或者已经过期的话是 nil，fn 需要在副本上修改，不能修改参数本身。
+// fn 返回参数本身表示不需要修改，返回 nil 表示删除这个数据，否则使用返回的数据替换掉原来的数据。
+func (s *segment) modify(key string, fn func(oldValue *value) (*value, error)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	oldValue, ok := s.Data[key]
+	if ok && !oldValue.alive() {
+		oldValue = nil
+	}
+
+	newValue, err := fn(oldValue)
+	if err != nil || newValue == oldValue {
+		return err
 	}
 
-	s.Status.addEntry(key, value)
-	s.Data[key] = newValue(value, ttl)
+	if newValue == nil {
+		_, err = s.deleteEntries([]string{key})
+		return err
+	}
+
+	if err = s.setValue(key, newValue); err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.Status.Sets, 1)
 	return nil
 }
 
+// ttl 返回 key 对应的数据还剩多长时间过期，永不过期的数据返回 NeverDie，第二个返回值表示 key 是否存在。
+func (s *segment) ttl(key string) (time.Duration, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	v, ok := s.Data[key]
+	if !ok || !v.alive() {
+		return 0, false
+	}
+
+	deadline := v.deadline()
+	if deadline == NeverDie {
+		return NeverDie, true
+	}
+	return time.Duration(deadline - time.Now().UnixNano()), true
+}
+
+// evict 淘汰指定 key 的数据，调用之前需要先加写锁。
+// 淘汰的数据也会作为删除操作写入日志，这样回放日志的时候就不会把淘汰掉的数据又恢复回来。
+func (s *segment) evict(key string) {
+	s.log(aofDeleteOp, key, nil)
+	if s.removeEntry(key) {
+		atomic.AddInt64(&s.Status.Evicted, 1)
+	}
+}
+
 // delete 从 segment 中删除指定 key 的数据。
-func (s *segment) delete(key string) {
+func (s *segment) delete(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.AddInt64(&s.Status.Deletes, 1)
+	if _, ok := s.Data[key]; ok {
+		if err := s.log(aofDeleteOp, key, nil); err != nil {
+			return err
+		}
+		s.removeEntry(key)
+	}
+	return nil
+}
+
+// removeEntry 从 segment 中移除指定 key 的数据，并更新数据块的情况和淘汰策略，返回这个 key 是否存在。
+// 调用之前需要先加写锁。
+func (s *segment) removeEntry(key string) bool {
+	oldValue, ok := s.Data[key]
+	if !ok {
+		return false
+	}
+	s.Status.subEntry(key, oldValue)
+	delete(s.Data, key)
+	s.evictor.remove(key)
+	s.expiry.remove(oldValue)
+	s.tags.remove(key, oldValue.Tags)
+	return true
+}
+
+// deleteByPrefix 删除这个 segment 中所有以 prefix 开头的数据，返回删除的数量。
+func (s *segment) deleteByPrefix(prefix string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var keys []string
+	for key := range s.Data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return s.deleteEntries(keys)
+}
+
+// deleteByTag 删除这个 segment 中所有带有 tag 这个标签的数据，返回删除的数量。
+func (s *segment) deleteByTag(tag string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.deleteEntries(s.tags.keysOf(tag))
+}
+
+// deleteEntries 删除 keys 对应的数据，每个删除操作都会写入日志，返回删除的数量，调用之前需要先加写锁。
+func (s *segment) deleteEntries(keys []string) (int, error) {
+	deleted := 0
+	for _, key := range keys {
+		if err := s.log(aofDeleteOp, key, nil); err != nil {
+			return deleted, err
+		}
+
+		if s.removeEntry(key) {
+			atomic.AddInt64(&s.Status.Deletes, 1)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// replaceWith 使用 other 的数据替换掉这个 segment 的数据，调用之前需要先加写锁。
+// 计数器记录的是历史操作，所以会保留下来，只有数据相关的情况会被替换。
+func (s *segment) replaceWith(other *segment) {
+	s.Data = other.Data
+	s.evictor = other.evictor
+	s.expiry = other.expiry
+	s.tags = other.tags
+	s.Status.Count = other.Status.Count
+	s.Status.KeySize = other.Status.KeySize
+	s.Status.ValueSize = other.Status.ValueSize
+	s.Status.MemorySize = other.Status.MemorySize
+}
+
+// log 把写操作追加到 AOF 中，没有开启 AOF 的时候什么也不做，调用之前需要先加写锁。
+func (s *segment) log(op byte, key string, v *value) error {
+	if s.aof == nil {
+		return nil
+	}
+	return s.aof.append(op, key, v)
+}
+
+// expire 删除已经过期的 key，用于查询的时候发现数据过期了的情况。
+// 因为释放读锁之后到获取写锁之前，这个 key 可能被重新设置过了，所以需要再判断一次是否过期。
+func (s *segment) expire(key string) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	if oldValue, ok := s.Data[key]; ok {
-		s.Status.subEntry(key, oldValue.Data)
-		delete(s.Data, key)
+	if oldValue, ok := s.Data[key]; ok && !oldValue.alive() {
+		s.removeEntry(key)
+		atomic.AddInt64(&s.Status.LazyExpired, 1)
+	}
+}
+
+// scan 把这个 segment 中匹配 pattern 的 key 追加到 keys 中并返回，已经过期的数据会被跳过。
+// pattern 已经检查过了，所以这里忽略 path.Match 的错误，空的 pattern 匹配所有的 key。
+func (s *segment) scan(pattern string, keys []string) []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for key, value := range s.Data {
+		if !value.alive() {
+			continue
+		}
+
+		if matched, _ := path.Match(pattern, key); pattern == "" || matched {
+			keys = append(keys, key)
+		}
 	}
+	return keys
 }
 
 // Status 返回这个 segment 的情况。
+// 计数器在读锁中也会被原子更新，所以这里不能直接复制，需要使用原子操作读取。
 func (s *segment) status() Status {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
-	return *s.Status
+	return s.currentStatus()
+}
+
+// currentStatus 返回这个 segment 当前的情况，调用之前需要先加锁。
+func (s *segment) currentStatus() Status {
+	return Status{
+		Gets:        atomic.LoadInt64(&s.Status.Gets),
+		Hits:        atomic.LoadInt64(&s.Status.Hits),
+		Misses:      atomic.LoadInt64(&s.Status.Misses),
+		Sets:        atomic.LoadInt64(&s.Status.Sets),
+		Deletes:     atomic.LoadInt64(&s.Status.Deletes),
+		Rejected:    atomic.LoadInt64(&s.Status.Rejected),
+		Evicted:     atomic.LoadInt64(&s.Status.Evicted),
+		LazyExpired: atomic.LoadInt64(&s.Status.LazyExpired),
+		GcExpired:   atomic.LoadInt64(&s.Status.GcExpired),
+		Count:       s.Status.Count,
+		KeySize:     s.Status.KeySize,
+		ValueSize:   s.Status.ValueSize,
+		MemorySize:  s.Status.MemorySize,
+	}
+}
+
+// snapshot 返回这个 segment 的快照，用于持久化。
+// 快照只复制 map 和 value 结构体，真正的数据在设置之后就不会被修改了，所以可以直接共享，这样持有读锁的时间就很短。
+func (s *segment) snapshot() *segment {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data := make(map[string]*value, len(s.Data))
+	for key, value := range s.Data {
+		data[key] = value.snapshot()
+	}
+
+	status := s.currentStatus()
+	return &segment{
+		Data:    data,
+		Status:  &status,
+		options: s.options,
+	}
 }
 
 // checkEntrySize 会判断数据容量是否已经达到了设定的上限。
 // 因为这个配置是针对整个缓存的，而这边判断大小是针对单个 segment 的，所以需要算出单个 segment 的上限来判断。
-func (s *segment) checkEntrySize(newKey string, newValue []byte) bool {
-	return s.Status.entrySize()+int64(len(newKey))+int64(len(newValue)) <= int64((s.options.MaxEntrySize*1024*1024) / s.options.SegmentSize)
+func (s *segment) checkEntrySize(newKey string, newValue *value) bool {
+	return s.Status.entrySize()+sizeOfEntry(newKey, newValue) <= s.maxEntrySize()
 }
 
-// gc 会清理 segment 中过期的数据。
-func (s *segment) gc() {
+// maxEntrySize 返回单个 segment 的容量上限，也就是平均分到每个 segment 的容量。
+func (s *segment) maxEntrySize() int64 {
+	return s.options.MaxEntrySize / int64(s.options.SegmentSize)
+}
+
+// gc 会清理 segment 中到期的数据，返回是否还有到期的数据没有处理。
+// 每次加锁最多处理 MaxGcCount 个数据，避免长时间持有写锁，还没处理完的话由调用者释放锁之后再继续调用。
+func (s *segment) gc(now int64) bool {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	count := 0
-	for key, value := range s.Data {
-		if !value.alive() {
-			s.Status.subEntry(key, value.Data)
-			delete(s.Data, key)
-			count++
-			if count >= s.options.MaxGcCount {
-				break
-			}
+	expired := 0
+	for item := s.expiry.peek(); item != nil && item.deadline <= now; item = s.expiry.peek() {
+		if count >= s.options.MaxGcCount {
+			atomic.AddInt64(&s.Status.GcExpired, int64(expired))
+			return true
 		}
+		count++
+
+		// 空闲过期的数据可能在这期间被访问过，所以需要重新计算过期时间，还没过期就按照新的过期时间放回去
+		if deadline := s.Data[item.key].deadline(); deadline > now {
+			s.expiry.reschedule(item, deadline)
+			continue
+		}
+
+		s.removeEntry(item.key)
+		expired++
 	}
+	atomic.AddInt64(&s.Status.GcExpired, int64(expired))
+	return false
 }
@@ -0,0 +1,126 @@
+package caches
+
+import (
+	"sort"
+	"time"
+)
+
+// SAdd 把 members 添加到 key 对应的集合中，返回新增的成员个数，已经存在的成员不会重复添加。
+// key 不存在的话会创建一个集合，ttl 和 opts 只在创建的时候使用，已经存在的集合会保留原来的有效期。
+// 修改成员的时候会复制出新的集合，所以成员很多的集合不适合频繁地修改。
+func (c *Cache) SAdd(key string, members []string, ttl time.Duration, opts ...SetOption) (int, error) {
+	added := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		newValue, err := c.valueToModify(oldValue, setType, ttl, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		set := make(map[string]bool, len(newValue.Set)+len(members))
+		for member := range newValue.Set {
+			set[member] = true
+		}
+
+		added = 0
+		for _, member := range members {
+			if !set[member] {
+				set[member] = true
+				added++
+			}
+		}
+
+		// 没有新增成员的话不需要修改，空的集合也不会被保存
+		if added == 0 {
+			return oldValue, nil
+		}
+		newValue.Set = set
+		return newValue, nil
+	})
+	return added, err
+}
+
+// SRem 从 key 对应的集合中移除 members，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (c *Cache) SRem(key string, members ...string) (int, error) {
+	removed := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		if oldValue == nil {
+			return nil, nil
+		}
+
+		if oldValue.Type != setType {
+			return nil, WrongTypeErr
+		}
+
+		set := make(map[string]bool, len(oldValue.Set))
+		for member := range oldValue.Set {
+			set[member] = true
+		}
+
+		removed = 0
+		for _, member := range members {
+			if set[member] {
+				delete(set, member)
+				removed++
+			}
+		}
+
+		if removed == 0 {
+			return oldValue, nil
+		}
+
+		if len(set) == 0 {
+			return nil, nil
+		}
+
+		newValue := oldValue.copyForUpdate()
+		newValue.Set = set
+		return newValue, nil
+	})
+	return removed, err
+}
+
+// SIsMember 返回 member 是否在 key 对应的集合中，key 不是集合的话返回 WrongTypeErr。
+func (c *Cache) SIsMember(key string, member string) (bool, error) {
+	v, ok, err := c.setOf(key)
+	if !ok || err != nil {
+		return false, err
+	}
+	return v.Set[member], nil
+}
+
+// SMembers 返回 key 对应的集合中所有的成员，按照字典序排序，key 不存在的话返回空的列表。
+func (c *Cache) SMembers(key string) ([]string, error) {
+	v, ok, err := c.setOf(key)
+	if !ok || err != nil {
+		return []string{}, err
+	}
+
+	members := make([]string, 0, len(v.Set))
+	for member := range v.Set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// SCard 返回 key 对应的集合的成员个数，key 不存在的话返回 0。
+func (c *Cache) SCard(key string) (int, error) {
+	v, ok, err := c.setOf(key)
+	if !ok || err != nil {
+		return 0, err
+	}
+	return len(v.Set), nil
+}
+
+// setOf 返回 key 对应的集合，第二个返回值表示 key 是否存在，key 不是集合的话返回 WrongTypeErr。
+func (c *Cache) setOf(key string) (*value, bool, error) {
+	v, ok := c.segmentOf(key).getValue(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if v.Type != setType {
+		return nil, false, WrongTypeErr
+	}
+	return v, true, nil
+}
@@ -0,0 +1,148 @@
+package caches
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// snapshotTimeLayout 是历史快照文件名中的时间格式，精确到毫秒，避免同一秒内的两次持久化覆盖掉对方。
+	snapshotTimeLayout = "20060102150405.000"
+
+	// tempFileSuffix 是还没有写完的文件的后缀，写完之后才会重命名成正式的文件名。
+	tempFileSuffix = ".tmp"
+)
+
+var (
+	// InvalidSnapshotErr 是恢复数据时指定的快照不是持久化文件旁边的历史快照时返回的错误。
+	InvalidSnapshotErr = errors.New("the snapshot should be a timestamp or the name of a snapshot next to the dump file")
+)
+
+// nowSuffix 返回当前时间作为历史快照文件的后缀，类似于 .20060102150405000。
+func nowSuffix() string {
+	return "." + strings.Replace(time.Now().Format(snapshotTimeLayout), ".", "", 1)
+}
+
+// isSnapshotSuffix 判断 suffix 是不是 nowSuffix 生成的时间戳。
+func isSnapshotSuffix(suffix string) bool {
+	if len(suffix) != len(snapshotTimeLayout)-1 {
+		return false
+	}
+
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// dumpSnapshots 返回 dumpFile 所有的历史快照文件，按照时间从旧到新排序。
+func dumpSnapshots(dumpFile string) ([]string, error) {
+	files, err := filepath.Glob(dumpFile + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]string, 0, len(files))
+	for _, file := range files {
+		if isSnapshotSuffix(strings.TrimPrefix(file, dumpFile+".")) {
+			snapshots = append(snapshots, file)
+		}
+	}
+
+	// 时间戳的长度都是一样的，所以直接按照字符串排序就是按照时间排序
+	sort.Strings(snapshots)
+	return snapshots, nil
+}
+
+// removeOldSnapshots 只保留 dumpFile 最新的 retention 个历史快照文件，更旧的都会被删除。
+func removeOldSnapshots(dumpFile string, retention int) error {
+	snapshots, err := dumpSnapshots(dumpFile)
+	if err != nil {
+		return err
+	}
+
+	if retention < 0 {
+		retention = 0
+	}
+
+	for i := 0; i < len(snapshots)-retention; i++ {
+		if err = os.Remove(snapshots[i]); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotFileOf 返回 snapshot 对应的历史快照文件。
+// snapshot 可以是快照文件的路径，也可以是快照文件名中的时间戳，比如 20060102150405000。
+func snapshotFileOf(dumpFile string, snapshot string) (string, error) {
+	if _, err := os.Stat(snapshot); err == nil || !isSnapshotSuffix(snapshot) {
+		return snapshot, err
+	}
+
+	snapshotFile := dumpFile + "." + snapshot
+	_, err := os.Stat(snapshotFile)
+	return snapshotFile, err
+}
+
+// restoreFileOf 返回 Restore 可以使用的历史快照文件，snapshot 是快照文件名中的时间戳或者快照的文件名，比如 kafo.dump.20060102150405000。
+// 这个名字来自客户端，所以只接受持久化文件所在目录中的历史快照，绝对路径、带有目录或者 .. 的名字都会返回 InvalidSnapshotErr，
+// 否则任何客户端都可以让节点读取服务器上的任意文件。
+func restoreFileOf(dumpFile string, snapshot string) (string, error) {
+	if dumpFile == "" || snapshot == "" || filepath.IsAbs(snapshot) || strings.ContainsAny(snapshot, `/\`) || strings.Contains(snapshot, "..") {
+		return "", InvalidSnapshotErr
+	}
+
+	prefix := filepath.Base(dumpFile) + "."
+	if !isSnapshotSuffix(snapshot) {
+		if !strings.HasPrefix(snapshot, prefix) || !isSnapshotSuffix(strings.TrimPrefix(snapshot, prefix)) {
+			return "", InvalidSnapshotErr
+		}
+		snapshot = strings.TrimPrefix(snapshot, prefix)
+	}
+
+	snapshotFile := filepath.Join(filepath.Dir(dumpFile), prefix+snapshot)
+	_, err := os.Stat(snapshotFile)
+	return snapshotFile, err
+}
+
+// replaceFile 使用 src 的内容原子地替换掉 dst，替换的过程中崩溃的话 dst 要么是旧的内容，要么是新的内容。
+// 这里优先使用硬链接，不支持硬链接的文件系统就复制一份，然后再重命名成 dst。
+func replaceFile(src string, dst string) error {
+	tempFile := dst + tempFileSuffix
+	os.Remove(tempFile)
+	if err := os.Link(src, tempFile); err != nil {
+		if err = copyFile(src, tempFile); err != nil {
+			os.Remove(tempFile)
+			return err
+		}
+	}
+	return os.Rename(tempFile, dst)
+}
+
+// copyFile 把 src 的内容复制到 dst 中，并在复制完之后刷盘。
+func copyFile(src string, dst string) error {
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFile.Close()
+
+	dstFile, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	if _, err = io.Copy(dstFile, srcFile); err != nil {
+		return err
+	}
+	return dstFile.Sync()
+}
@@ -1,9 +1,69 @@
 package caches
 
+import "unsafe"
+
+const (
+	// mapEntryOverhead 是 map 中每个键值对的额外开销估算值，单位是字节。
+	// 包括 key 的字符串头（16 字节）、指向 value 的指针（8 字节）、桶中的 tophash 和溢出指针，
+	// 以及 map 负载因子带来的空闲位置，这里按照经验取一个稍微偏大的值。
+	mapEntryOverhead = 48
+
+	// listElementOverhead 是列表中每个元素的额外开销估算值，也就是切片头的大小，单位是字节。
+	listElementOverhead = int64(unsafe.Sizeof([]byte(nil)))
+
+	// zsetMemberOverhead 是有序集合中每个成员的额外开销估算值，也就是成员的字符串头，分数已经算在数据大小里了，单位是字节。
+	zsetMemberOverhead = int64(unsafe.Sizeof(ZMember{})) - 8
+)
+
+// entryOverhead 是每个键值对除了 key 和 value 的数据之外额外占用的内存估算值，单位是字节。
+var entryOverhead = int64(unsafe.Sizeof(value{})) + mapEntryOverhead
+
 // Status 是一个代表缓存信息的结构体。
 // 因为这个结构体需要被序列化为 Json 字符串并通过网络传输，所以这里使用到了 Json 的标签。
+// 注意下面的计数器都是使用 atomic 包进行原子更新的，所以要放在结构体的最前面，保证在 32 位平台上也是 64 位对齐的。
 type Status struct {
 
+	// Gets 记录着查询数据的次数。
+	Gets int64 `json:"gets"`
+
+	// Hits 记录着查询数据命中的次数。
+	Hits int64 `json:"hits"`
+
+	// Misses 记录着查询数据没有命中的次数，包括数据已经过期的情况。
+	Misses int64 `json:"misses"`
+
+	// Sets 记录着成功添加数据的次数。
+	Sets int64 `json:"sets"`
+
+	// Deletes 记录着删除数据的次数。
+	Deletes int64 `json:"deletes"`
+
+	// Rejected 记录着因为缓存写满而被拒绝写入的次数。
+	Rejected int64 `json:"rejected"`
+
+	// Evicted 记录着被淘汰策略淘汰的数据个数。
+	Evicted int64 `json:"evicted"`
+
+	// LazyExpired 记录着在查询的时候才发现过期而被清理的数据个数。
+	LazyExpired int64 `json:"lazyExpired"`
+
+	// GcExpired 记录着被 gc 清理的过期数据个数。
+	GcExpired int64 `json:"gcExpired"`
+
+	// GcRuns 记录着 gc 执行的次数。
+	GcRuns int64 `json:"gcRuns"`
+
+	// GcTime 记录着 gc 累计消耗的时间。
+	// 单位是纳秒。
+	GcTime int64 `json:"gcTime"`
+
+	// DumpRuns 记录着持久化执行的次数。
+	DumpRuns int64 `json:"dumpRuns"`
+
+	// DumpTime 记录着持久化累计消耗的时间。
+	// 单位是纳秒。
+	DumpTime int64 `json:"dumpTime"`
+
 	// Count 记录着缓存中的数据个数。
 	Count int `json:"count"`
 
@@ -12,35 +72,67 @@ type Status struct {
 
 	// ValueSize 记录着 value 占用的空间大小。
 	ValueSize int64 `json:"valueSize"`
+
+	// MemorySize 记录着缓存数据实际占用内存的估算值，除了 key 和 value 还包括每个键值对的额外开销。
+	MemorySize int64 `json:"memorySize"`
 }
 
 // newStatus 返回一个缓存信息对象指针。
 func NewStatus() *Status {
 	return &Status{
-		Count:     0,
-		KeySize:   0,
-		ValueSize: 0,
+		Count:      0,
+		KeySize:    0,
+		ValueSize:  0,
+		MemorySize: 0,
 	}
 }
 
+// Merge 把 other 的信息累加到当前的缓存信息中，主要用于汇总多个 segment 或者多个节点的情况。
+func (s *Status) Merge(other *Status) {
+	s.Gets += other.Gets
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.Sets += other.Sets
+	s.Deletes += other.Deletes
+	s.Rejected += other.Rejected
+	s.Evicted += other.Evicted
+	s.LazyExpired += other.LazyExpired
+	s.GcExpired += other.GcExpired
+	s.GcRuns += other.GcRuns
+	s.GcTime += other.GcTime
+	s.DumpRuns += other.DumpRuns
+	s.DumpTime += other.DumpTime
+	s.Count += other.Count
+	s.KeySize += other.KeySize
+	s.ValueSize += other.ValueSize
+	s.MemorySize += other.MemorySize
+}
+
+// sizeOfEntry 返回一个键值对实际占用内存的估算值。
+func sizeOfEntry(key string, v *value) int64 {
+	return int64(len(key)) + v.dataSize() + v.overhead() + entryOverhead
+}
+
 // addEntry 可以将 key 和 value 的信息记录起来。
-func (s *Status) addEntry(key string, value []byte) {
+func (s *Status) addEntry(key string, v *value) {
     // 每添加一个键值对，count 就需要加 1，key 占用的空间就是 string 的长度。
-    // 同理，value 占用的空间就是切片的长度。
+    // 同理，value 占用的空间就是数据的长度，哈希类型的数据是所有字段名和字段值的长度之和。
 	s.Count++
 	s.KeySize += int64(len(key))
-	s.ValueSize += int64(len(value))
+	s.ValueSize += v.dataSize()
+	s.MemorySize += sizeOfEntry(key, v)
 }
 
 // subEntry 可以将 key 和 value 的信息从 Status 中减去。
-func (s *Status) subEntry(key string, value []byte) {
+func (s *Status) subEntry(key string, v *value) {
     // 每减少一个键值对，count 就需要减 1，key 和 value 占用的空间也需要减去相应的大小。
 	s.Count--
 	s.KeySize -= int64(len(key))
-	s.ValueSize -= int64(len(value))
+	s.ValueSize -= v.dataSize()
+	s.MemorySize -= sizeOfEntry(key, v)
 }
 
 // entrySize 返回键值对占用的总大小。
 func (s *Status) entrySize() int64 {
-	return s.KeySize + s.ValueSize
+	return s.MemorySize
 }
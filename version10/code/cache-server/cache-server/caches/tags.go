@@ -0,0 +1,46 @@
+package caches
+
+// tagIndex 是标签到 key 的索引，用于按照标签批量删除数据，不需要遍历整个 map。
+// 每个 segment 都有自己的索引，所以和数据一样由 segment 的锁保护。
+type tagIndex map[string]map[string]struct{}
+
+// newTagIndex 返回一个空的标签索引。
+func newTagIndex() tagIndex {
+	return make(tagIndex)
+}
+
+// add 把 key 添加到 tags 中每个标签的索引里。
+func (ti tagIndex) add(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := ti[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			ti[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+// remove 把 key 从 tags 中每个标签的索引里移除，没有 key 的标签也会被移除，避免索引越来越大。
+func (ti tagIndex) remove(key string, tags []string) {
+	for _, tag := range tags {
+		keys, ok := ti[tag]
+		if !ok {
+			continue
+		}
+
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(ti, tag)
+		}
+	}
+}
+
+// keysOf 返回带有 tag 这个标签的所有 key。
+func (ti tagIndex) keysOf(tag string) []string {
+	keys := make([]string, 0, len(ti[tag]))
+	for key := range ti[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -10,51 +10,211 @@ import (
 const (
 	// NeverDie 是一个常量，我们设计的时候规定如果 ttl 为 0，那就是永不过期，相当于灵丹妙药。
 	NeverDie = 0
+
+	// Forever 表示数据永不过期，和 NeverDie 不同的是它不会被替换成默认有效期，
+	// 所以设置了默认有效期的缓存或者命名空间也可以使用它添加永不过期的数据。
+	Forever = -1
+)
+
+const (
+	// stringType 是普通的数据类型，数据存储在 Data 中。
+	stringType = byte(0)
+
+	// hashType 是哈希类型，数据存储在 Hash 中，可以单独读写其中的字段。
+	hashType = byte(1)
+
+	// listType 是列表类型，数据存储在 List 中，可以从两端插入和弹出元素。
+	listType = byte(2)
+
+	// setType 是集合类型，成员存储在 Set 中，同一个成员只会出现一次。
+	setType = byte(3)
+
+	// zsetType 是有序集合类型，成员和分数存储在 ZSet 中，按照分数从小到大排列。
+	zsetType = byte(4)
 )
 
 // value 是一个包装了数据的结构体。
+// 数据有两种过期方式，一种是从创建开始计算的绝对过期，一种是从最后一次访问开始计算的空闲过期，两种方式可以同时使用。
 type value struct {
 
 	// data 存储着真正的数据。
 	Data []byte
 
-	// ttl 代表这个数据的寿命。
-	// 这个值的单位是秒。
+	// ttl 代表这个数据从创建开始的寿命，NeverDie 表示没有限制。
+	// 这个值的单位是纳秒，也就是 time.Duration。
 	Ttl int64
 
 	// ctime 代表这个数据的创建时间。
+	// 这个值是 Unix 时间戳，单位是纳秒。
 	Ctime int64
+
+	// Idle 代表这个数据最长的空闲时间，超过这个时间没有被访问就会过期，NeverDie 表示没有限制。
+	// 这个值的单位是纳秒，也就是 time.Duration。
+	Idle int64
+
+	// Atime 代表这个数据最后一次被访问的时间，只有设置了 Idle 才会更新。
+	// 这个值是 Unix 时间戳，单位是纳秒。
+	Atime int64
+
+	// Version 是这个数据的版本号，每次修改数据都会分配一个更大的版本号，用于实现 CAS 操作。
+	// 0 表示还没有分配版本号，添加到 segment 的时候才会分配。
+	Version uint64
+
+	// Tags 是这个数据的标签，用于按照标签批量删除数据。
+	Tags []string
+
+	// Type 是这个数据的类型，0 是普通的数据，所以旧版本持久化的数据都会被当作普通的数据。
+	Type byte
+
+	// Hash 存储着哈希类型的所有字段，其他类型的数据为空。
+	// 和 Data 一样，设置之后就不会被修改了，修改字段的时候会复制出新的 map，这样读取和持久化的时候都不需要复制。
+	Hash map[string][]byte
+
+	// List 存储着列表类型的所有元素，其他类型的数据为空。
+	// 弹出和追加元素的时候会和旧的数据共享底层数组，但是不会修改旧的数据看得到的部分，所以和 Data 一样可以直接共享。
+	List [][]byte
+
+	// Set 存储着集合类型的所有成员，其他类型的数据为空。
+	// 成员对应的值总是 true，因为 gob 不能编码空的结构体，所以没有使用 struct{}。
+	Set map[string]bool
+
+	// ZSet 存储着有序集合类型的所有成员，按照分数从小到大排列，分数相同的按照成员的字典序排列，其他类型的数据为空。
+	// 和 Hash 一样，修改的时候会复制出新的切片，这样按照排名和分数查询的时候都不需要再排序。
+	ZSet []ZMember
+
+	// expiry 是这个数据在过期队列中的位置，不会过期的数据为空。
+	// 这个字段不是导出字段，所以不会被持久化，恢复的时候会重新加入过期队列。
+	expiry *expiryItem
 }
 
 // newValue 返回一个包装之后的数据。
-func newValue(data []byte, ttl int64) *value {
+func newValue(data []byte, ttl time.Duration) *value {
+	now := time.Now().UnixNano()
 	return &value{
         // 这里使用 Copy 是为了让这个数据和外界没有任何联系
         // 实际上也可以不复制，性能还更高，只要保证外界不会更改就可以了
         // 这里我们进行复制是因为从设计上来说最好这么干，但是后面为了性能可能就会去除掉这个复制步骤了
         // 所以从这也能看出，有时候需要做一些反设计的设计
 		Data:  helpers.Copy(data),
-		Ttl:   ttl,
-		Ctime: time.Now().Unix(),
+		Ttl:   int64(ttl),
+		Ctime: now,
+		Atime: now,
+	}
+}
+
+// newValueWith 返回一个按照 options 设置了过期方式和标签的数据。
+// 空闲过期的时候 ttl 是空闲时间，MaxAge 是从创建开始的最长寿命，绝对过期的时候 MaxAge 只会缩短 ttl。
+func newValueWith(data []byte, ttl time.Duration, options SetOptions) *value {
+	v := newValue(data, ttl)
+	if options.Sliding {
+		v.Idle = v.Ttl
+		v.Ttl = NeverDie
+	}
+
+	if options.MaxAge > 0 && (v.Ttl == NeverDie || int64(options.MaxAge) < v.Ttl) {
+		v.Ttl = int64(options.MaxAge)
 	}
+	v.Tags = options.Tags
+	return v
 }
 
 // alive 返回这个数据是否存活。
 func (v *value) alive() bool {
     // 首先判断是否有过期时间，然后判断当前时间是否超过了这个数据的死期
-	return v.Ttl == NeverDie || time.Now().Unix()-v.Ctime < v.Ttl
+	deadline := v.deadline()
+	return deadline == NeverDie || time.Now().UnixNano() < deadline
+}
+
+// deadline 返回这个数据的过期时间，Unix 时间戳，单位是纳秒，永不过期的数据返回 NeverDie。
+// 两种过期方式同时使用的时候，哪个先到期就以哪个为准。
+func (v *value) deadline() int64 {
+	deadline := int64(NeverDie)
+	if v.Ttl != NeverDie {
+		deadline = v.Ctime + v.Ttl
+	}
+
+	if v.Idle != NeverDie {
+		idleDeadline := atomic.LoadInt64(&v.Atime) + v.Idle
+		if deadline == NeverDie || idleDeadline < deadline {
+			deadline = idleDeadline
+		}
+	}
+	return deadline
 }
 
 // visit 返回这个数据的实际存储数据。
 func (v *value) visit() []byte {
-    // 这一步是为了实现 LRU 过期机制而加的
-    // 在访问数据的时候，将创建时间更新为访问时间，这样就相当于最近访问的数据过期时间会延长
+    // 设置了空闲时间的数据，在访问的时候需要将访问时间更新为当前时间，这样最近访问的数据过期时间就会延长
+    // 只设置了 ttl 的数据是绝对过期的，不管访问多少次都会按时过期，所以不需要更新
     // 因为获取数据一般都在读取操作中进行，读取操作使用的是读锁，尽可能保证并发的性能
-    // 使用读锁就意味着没有保证写的并发安全，所以我们需要自己去处理并发安全的问题
-    // 一般会使用锁来处理，但是这里牵扯到读取性能，如果使用锁，就会显得非常臃肿和拉跨
-    // 于是就使用了 atomic 包轻量化地去处理，这里直接使用了交换更新数据，而没有使用 CAS 的方式
-    // 后交换成功的会把先交换成功的时间改掉，所以这里不保证交换的时间一定是更加新的时间
-    // 有兴趣的童鞋可以尝试使用 CAS 的方式去更新，注意 CAS 的重试次数限制，防止高并发的时候 CPU 浪费严重
-	atomic.SwapInt64(&v.Ctime, time.Now().Unix())
+    // 使用读锁就意味着没有保证写的并发安全，所以这里使用 atomic 包轻量化地去处理
+    // 后更新的会把先更新的时间改掉，所以这里不保证更新的时间一定是更加新的时间，不过误差很小，可以接受
+	if v.Idle != NeverDie {
+		atomic.StoreInt64(&v.Atime, time.Now().UnixNano())
+	}
 	return v.Data
 }
+
+// snapshot 返回这个数据的一个副本，用于持久化。
+// 因为 visit 会在读锁中原子地更新 Atime，所以这里也需要使用原子操作读取。
+func (v *value) snapshot() *value {
+	return &value{
+		Data:    v.Data,
+		Ttl:     v.Ttl,
+		Ctime:   v.Ctime,
+		Idle:    v.Idle,
+		Atime:   atomic.LoadInt64(&v.Atime),
+		Version: v.Version,
+		Tags:    v.Tags,
+		Type:    v.Type,
+		Hash:    v.Hash,
+		List:    v.List,
+		Set:     v.Set,
+		ZSet:    v.ZSet,
+	}
+}
+
+// copyForUpdate 返回用于修改数据的副本，版本号会被清空，这样添加的时候会分配一个新的版本号。
+// 修改也算是一次访问，所以空闲过期的数据会重新计算空闲时间。
+func (v *value) copyForUpdate() *value {
+	newValue := v.snapshot()
+	newValue.Version = 0
+	if newValue.Idle != NeverDie {
+		newValue.Atime = time.Now().UnixNano()
+	}
+	return newValue
+}
+
+// dataSize 返回数据本身占用的空间大小，哈希类型的数据是所有字段名和字段值的长度之和，列表类型的数据是所有元素的长度之和。
+// 集合类型的数据是所有成员的长度之和，有序集合类型的数据还要加上分数的 8 个字节。
+func (v *value) dataSize() int64 {
+	size := int64(len(v.Data))
+	for field, data := range v.Hash {
+		size += int64(len(field) + len(data))
+	}
+
+	for _, data := range v.List {
+		size += int64(len(data))
+	}
+
+	for member := range v.Set {
+		size += int64(len(member))
+	}
+
+	for _, member := range v.ZSet {
+		size += int64(len(member.Member)) + 8
+	}
+	return size
+}
+
+// overhead 返回数据除了本身之外额外占用的内存估算值，比如哈希类型中每个字段在 map 中的开销和列表类型中每个元素的切片头。
+func (v *value) overhead() int64 {
+	return int64(len(v.Hash)+len(v.Set))*mapEntryOverhead + int64(len(v.List))*listElementOverhead + int64(len(v.ZSet))*zsetMemberOverhead
+}
+
+// secondsToNanos 把 Ttl 和 Ctime 的单位从秒转换成纳秒，用于迁移精度还是秒的旧数据。
+func (v *value) secondsToNanos() *value {
+	v.Ttl *= int64(time.Second)
+	v.Ctime *= int64(time.Second)
+	return v
+}
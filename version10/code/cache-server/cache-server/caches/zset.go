@@ -0,0 +1,282 @@
+package caches
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	// InvalidScoreErr 是有序集合的分数不是一个数字时返回的错误，比如正无穷加上负无穷。
+	InvalidScoreErr = errors.New("the score is not a valid number")
+)
+
+// ZMember 是有序集合中的一个成员和它的分数。
+type ZMember struct {
+
+	// Member 是成员的名字。
+	Member string `json:"member"`
+
+	// Score 是成员的分数，有序集合按照分数从小到大排列。
+	Score float64 `json:"score"`
+}
+
+// less 返回 m 是否应该排在 other 前面，分数相同的按照成员的字典序排列，这样顺序是确定的。
+func (m ZMember) less(other ZMember) bool {
+	if m.Score != other.Score {
+		return m.Score < other.Score
+	}
+	return m.Member < other.Member
+}
+
+// ZAdd 把 members 中的成员和分数添加到 key 对应的有序集合中，返回新增的成员个数，已经存在的成员会更新分数。
+// key 不存在的话会创建一个有序集合，ttl 和 opts 只在创建的时候使用，已经存在的有序集合会保留原来的有效期。
+// 分数是 NaN 的话返回 InvalidScoreErr，所有成员都不会被添加。
+func (c *Cache) ZAdd(key string, members map[string]float64, ttl time.Duration, opts ...SetOption) (int, error) {
+	for _, score := range members {
+		if math.IsNaN(score) {
+			return 0, InvalidScoreErr
+		}
+	}
+
+	added := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		newValue, err := c.valueToModify(oldValue, zsetType, ttl, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		// 空的有序集合不会被保存，否则持久化之后就变成普通的数据了
+		if len(members) == 0 {
+			return oldValue, nil
+		}
+
+		zset := newValue.ZSet
+		newValue.ZSet = mergeZSet(zset, members)
+		added = len(newValue.ZSet) - len(zset)
+		return newValue, nil
+	})
+	return added, err
+}
+
+// ZIncrBy 把 key 对应的有序集合中 member 的分数加上 delta，返回加上之后的分数，成员不存在的话当作分数是 0。
+// key 不存在的话会创建一个有序集合，ttl 和 opts 只在创建的时候使用，已经存在的有序集合会保留原来的有效期。
+func (c *Cache) ZIncrBy(key string, member string, delta float64, ttl time.Duration, opts ...SetOption) (float64, error) {
+	var score float64
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		newValue, err := c.valueToModify(oldValue, zsetType, ttl, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		score = delta
+		if i := indexOfZMember(newValue.ZSet, member); i >= 0 {
+			score += newValue.ZSet[i].Score
+		}
+
+		if math.IsNaN(score) {
+			return nil, InvalidScoreErr
+		}
+
+		newValue.ZSet = mergeZSet(newValue.ZSet, map[string]float64{member: score})
+		return newValue, nil
+	})
+	return score, err
+}
+
+// ZRem 从 key 对应的有序集合中移除 members，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (c *Cache) ZRem(key string, members ...string) (int, error) {
+	removed := 0
+	err := c.segmentOf(key).modify(key, func(oldValue *value) (*value, error) {
+		if oldValue == nil {
+			return nil, nil
+		}
+
+		if oldValue.Type != zsetType {
+			return nil, WrongTypeErr
+		}
+
+		toRemove := make(map[string]bool, len(members))
+		for _, member := range members {
+			toRemove[member] = true
+		}
+
+		zset := make([]ZMember, 0, len(oldValue.ZSet))
+		for _, member := range oldValue.ZSet {
+			if !toRemove[member.Member] {
+				zset = append(zset, member)
+			}
+		}
+
+		removed = len(oldValue.ZSet) - len(zset)
+		if removed == 0 {
+			return oldValue, nil
+		}
+
+		if len(zset) == 0 {
+			return nil, nil
+		}
+
+		newValue := oldValue.copyForUpdate()
+		newValue.ZSet = zset
+		return newValue, nil
+	})
+	return removed, err
+}
+
+// ZScore 返回 key 对应的有序集合中 member 的分数，第二个返回值表示成员是否存在，key 不是有序集合的话返回 WrongTypeErr。
+func (c *Cache) ZScore(key string, member string) (float64, bool, error) {
+	v, ok, err := c.zsetOf(key)
+	if !ok || err != nil {
+		return 0, false, err
+	}
+
+	i := indexOfZMember(v.ZSet, member)
+	if i < 0 {
+		return 0, false, nil
+	}
+	return v.ZSet[i].Score, true, nil
+}
+
+// ZRank 返回 key 对应的有序集合中 member 按照分数从小到大的排名，从 0 开始，第二个返回值表示成员是否存在。
+func (c *Cache) ZRank(key string, member string) (int, bool, error) {
+	return c.zrank(key, member, false)
+}
+
+// ZRevRank 返回 key 对应的有序集合中 member 按照分数从大到小的排名，从 0 开始，第二个返回值表示成员是否存在。
+func (c *Cache) ZRevRank(key string, member string) (int, bool, error) {
+	return c.zrank(key, member, true)
+}
+
+// zrank 返回 member 的排名，reverse 为 true 表示按照分数从大到小排名。
+func (c *Cache) zrank(key string, member string, reverse bool) (int, bool, error) {
+	v, ok, err := c.zsetOf(key)
+	if !ok || err != nil {
+		return 0, false, err
+	}
+
+	i := indexOfZMember(v.ZSet, member)
+	if i < 0 {
+		return 0, false, nil
+	}
+
+	if reverse {
+		i = len(v.ZSet) - 1 - i
+	}
+	return i, true, nil
+}
+
+// ZRange 返回 key 对应的有序集合中按照分数从小到大排名从 start 到 stop 的成员，包括 stop，key 不存在的话返回空的列表。
+// 和 LRange 一样，负数的排名表示从尾部开始数，所以 ZRange(key, 0, -1) 返回所有的成员。
+func (c *Cache) ZRange(key string, start int, stop int) ([]ZMember, error) {
+	return c.zrange(key, start, stop, false)
+}
+
+// ZRevRange 返回 key 对应的有序集合中按照分数从大到小排名从 start 到 stop 的成员，包括 stop，适合用来查询排行榜的前几名。
+func (c *Cache) ZRevRange(key string, start int, stop int) ([]ZMember, error) {
+	return c.zrange(key, start, stop, true)
+}
+
+// zrange 返回排名从 start 到 stop 的成员，reverse 为 true 表示按照分数从大到小排名。
+func (c *Cache) zrange(key string, start int, stop int, reverse bool) ([]ZMember, error) {
+	v, ok, err := c.zsetOf(key)
+	if !ok || err != nil {
+		return []ZMember{}, err
+	}
+
+	length := len(v.ZSet)
+	start, stop = listRangeOf(length, start, stop)
+	members := make([]ZMember, 0, stop-start)
+	for i := start; i < stop; i++ {
+		if reverse {
+			members = append(members, v.ZSet[length-1-i])
+		} else {
+			members = append(members, v.ZSet[i])
+		}
+	}
+	return members, nil
+}
+
+// ZRangeByScore 返回 key 对应的有序集合中分数在 min 和 max 之间的成员，包括 min 和 max，按照分数从小到大排列。
+func (c *Cache) ZRangeByScore(key string, min float64, max float64) ([]ZMember, error) {
+	v, ok, err := c.zsetOf(key)
+	if !ok || err != nil {
+		return []ZMember{}, err
+	}
+
+	zset := v.ZSet
+	start := sort.Search(len(zset), func(i int) bool {
+		return zset[i].Score >= min
+	})
+
+	stop := sort.Search(len(zset), func(i int) bool {
+		return zset[i].Score > max
+	})
+
+	if start >= stop {
+		return []ZMember{}, nil
+	}
+	return append([]ZMember(nil), zset[start:stop]...), nil
+}
+
+// ZCard 返回 key 对应的有序集合的成员个数，key 不存在的话返回 0。
+func (c *Cache) ZCard(key string) (int, error) {
+	v, ok, err := c.zsetOf(key)
+	if !ok || err != nil {
+		return 0, err
+	}
+	return len(v.ZSet), nil
+}
+
+// zsetOf 返回 key 对应的有序集合，第二个返回值表示 key 是否存在，key 不是有序集合的话返回 WrongTypeErr。
+func (c *Cache) zsetOf(key string) (*value, bool, error) {
+	v, ok := c.segmentOf(key).getValue(key)
+	if !ok {
+		return nil, false, nil
+	}
+
+	if v.Type != zsetType {
+		return nil, false, WrongTypeErr
+	}
+	return v, true, nil
+}
+
+// indexOfZMember 返回 member 在 zset 中的下标，不存在的话返回 -1。
+// 有序集合是按照分数排列的，不知道分数的话只能从头开始找。
+func indexOfZMember(zset []ZMember, member string) int {
+	for i := range zset {
+		if zset[i].Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// mergeZSet 返回把 members 合并到 zset 之后的新的有序集合，已经存在的成员会使用 members 中的分数，zset 本身不会被修改。
+func mergeZSet(zset []ZMember, members map[string]float64) []ZMember {
+	added := make([]ZMember, 0, len(members))
+	for member, score := range members {
+		added = append(added, ZMember{Member: member, Score: score})
+	}
+
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].less(added[j])
+	})
+
+	// 旧的成员和新的成员都是有序的，所以归并一次就可以了
+	result := make([]ZMember, 0, len(zset)+len(added))
+	i := 0
+	for _, member := range zset {
+		if _, ok := members[member.Member]; ok {
+			continue
+		}
+
+		for i < len(added) && added[i].less(member) {
+			result = append(result, added[i])
+			i++
+		}
+		result = append(result, member)
+	}
+	return append(result, added[i:]...)
+}
@@ -1,11 +1,12 @@
 module cache-server
 
-go 1.12
+go 1.13
 
 require (
 	github.com/FishGoddess/cachego v0.1.1
 	github.com/FishGoddess/vex v0.1.2
 	github.com/hashicorp/memberlist v0.1.5
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/klauspost/compress v1.13.4
 	stathat.com/c/consistent v1.0.0
 )
@@ -1,8 +1,68 @@
 package helpers
 
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// byteUnits 是支持的容量单位，使用的是 1024 进制。
+// 注意匹配的时候需要先匹配长的单位，否则 "MB" 会被当成 "B" 处理。
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{suffix: "KIB", size: 1 << 10},
+	{suffix: "MIB", size: 1 << 20},
+	{suffix: "GIB", size: 1 << 30},
+	{suffix: "TIB", size: 1 << 40},
+	{suffix: "KB", size: 1 << 10},
+	{suffix: "MB", size: 1 << 20},
+	{suffix: "GB", size: 1 << 30},
+	{suffix: "TB", size: 1 << 40},
+	{suffix: "K", size: 1 << 10},
+	{suffix: "M", size: 1 << 20},
+	{suffix: "G", size: 1 << 30},
+	{suffix: "T", size: 1 << 40},
+	{suffix: "B", size: 1},
+}
+
 // Copy 复制 src 到新的 []byte 中并返回。
 func Copy(src []byte) []byte {
 	dst := make([]byte, len(src))
 	copy(dst, src)
 	return dst
 }
+
+// ParseByteSize 解析带单位的容量字符串，比如 "512MB" 和 "4GB"，返回对应的字节数。
+// 单位不区分大小写，没有单位的数字会被当成字节数，负数、Inf、NaN 和超出 int64 范围的容量都会返回错误。
+func ParseByteSize(s string) (int64, error) {
+	str := strings.ToUpper(strings.TrimSpace(s))
+	unit := int64(1)
+	for _, byteUnit := range byteUnits {
+		if strings.HasSuffix(str, byteUnit.suffix) {
+			str = strings.TrimSpace(strings.TrimSuffix(str, byteUnit.suffix))
+			unit = byteUnit.size
+			break
+		}
+	}
+
+	// ParseFloat 可以解析 Inf 和 NaN，而且乘上单位之后可能超出 int64 的范围，这些都不是合法的容量
+	size, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(size) || size < 0 || size*float64(unit) >= math.MaxInt64 {
+		return 0, errors.New("invalid byte size " + s)
+	}
+	return int64(size * float64(unit)), nil
+}
+
+// FormatByteSize 把字节数格式化成带单位的字符串，比如 536870912 会被格式化成 "512MB"。
+func FormatByteSize(size int64) string {
+	for i := len(byteUnits) - 2; i >= 0; i-- {
+		byteUnit := byteUnits[i]
+		if len(byteUnit.suffix) == 2 && size >= byteUnit.size && size%byteUnit.size == 0 {
+			return strconv.FormatInt(size/byteUnit.size, 10) + byteUnit.suffix
+		}
+	}
+	return strconv.FormatInt(size, 10) + "B"
+}
@@ -0,0 +1,17 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration 解析带单位的时间长度，比如 250ms、1.5s 和 1h30m，支持的单位和 time.ParseDuration 一样。
+// 没有单位的纯数字会使用 defaultUnit 作为单位，这样可以兼容之前只支持秒的写法。
+func ParseDuration(s string, defaultUnit time.Duration) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(n) * defaultUnit, nil
+	}
+	return time.ParseDuration(s)
+}
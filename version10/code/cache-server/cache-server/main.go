@@ -1,14 +1,51 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
+	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"cache-server/caches"
+	"cache-server/helpers"
 	"cache-server/servers"
 )
 
+// byteSizeValue 是支持容量单位的 flag 值，比如 512MB。
+type byteSizeValue int64
+
+// newByteSizeValue 返回一个绑定了 p 的 byteSizeValue。
+func newByteSizeValue(p *int64) *byteSizeValue {
+	return (*byteSizeValue)(p)
+}
+
+// Set 解析 flag 传进来的字符串。
+// 以前的版本中容量的单位是 GB，为了兼容旧的启动参数，没有单位的数字仍然当作 GB，比如 4 就是 4GB。
+func (bsv *byteSizeValue) Set(s string) error {
+	str := strings.TrimSpace(s)
+	if _, err := strconv.ParseFloat(str, 64); err == nil {
+		str += "GB"
+	}
+
+	size, err := helpers.ParseByteSize(str)
+	if err != nil {
+		return errors.New("invalid byte size " + s)
+	}
+	*bsv = byteSizeValue(size)
+	return nil
+}
+
+// String 返回带单位的容量字符串，用于在帮助信息中显示默认值。
+func (bsv *byteSizeValue) String() string {
+	return helpers.FormatByteSize(int64(*bsv))
+}
+
 func main() {
 
 	// 准备服务器的选项配置
@@ -18,25 +55,38 @@ func main() {
 	flag.StringVar(&serverOptions.ServerType, "serverType", serverOptions.ServerType, "The type of server (http, tcp).")
 	flag.IntVar(&serverOptions.VirtualNodeCount, "virtualNodeCount", serverOptions.VirtualNodeCount, "The number of virtual nodes in consistent hash.")
 	flag.IntVar(&serverOptions.UpdateCircleDuration, "updateCircleDuration", serverOptions.UpdateCircleDuration, "The duration between two circle updating operations. The unit is second.")
+	flag.StringVar(&serverOptions.MetricsAddress, "metricsAddress", serverOptions.MetricsAddress, "The http address used to expose metrics of tcp server, such as 127.0.0.1:5838.")
 	cluster := flag.String("cluster", "", "The cluster of servers. One node in cluster will be ok.")
+	shutdownTimeout := flag.Int("shutdownTimeout", 30, "The max duration of graceful shutdown, including draining requests and the final dump. The unit is second.")
 
     // 准备缓存的选项配置
 	cacheOptions := caches.DefaultOptions()
-	flag.IntVar(&cacheOptions.MaxEntrySize, "maxEntrySize", cacheOptions.MaxEntrySize, "The max memory size that entries can use. The unit is GB.")
-	flag.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of entries that gc will clean.")
+	flag.Var(newByteSizeValue(&cacheOptions.MaxEntrySize), "maxEntrySize", "The max memory size that entries can use, such as 512MB or 4GB. The unit is GB if no unit is given, same as older versions, so use 512B for bytes.")
+	flag.IntVar(&cacheOptions.MaxGcCount, "maxGcCount", cacheOptions.MaxGcCount, "The max count of expired entries that gc will handle in one segment lock.")
 	flag.IntVar(&cacheOptions.GcDuration, "gcDuration", cacheOptions.GcDuration, "The duration between two gc tasks. The unit is Minute.")
+	flag.IntVar(&cacheOptions.ExpiryInterval, "expiryInterval", cacheOptions.ExpiryInterval, "The duration between two active expiry tasks. The unit is Millisecond, and 0 disables active expiry.")
 	flag.StringVar(&cacheOptions.DumpFile, "dumpFile", cacheOptions.DumpFile, "The file used to dump the cache.")
 	flag.IntVar(&cacheOptions.DumpDuration, "dumpDuration", cacheOptions.DumpDuration, "The duration between two dump tasks. The unit is Minute.")
+	flag.StringVar(&cacheOptions.DumpCompression, "dumpCompression", cacheOptions.DumpCompression, "The compression of dump file (none, gzip, zstd, snappy).")
+	flag.IntVar(&cacheOptions.DumpRetention, "dumpRetention", cacheOptions.DumpRetention, "The number of timestamped snapshots kept besides the dump file.")
+	flag.StringVar(&cacheOptions.RecoverSnapshot, "recoverSnapshot", cacheOptions.RecoverSnapshot, "The snapshot used to recover when starting, which can be a path or a timestamp like 20060102150405000. Empty means the dump file.")
 	flag.IntVar(&cacheOptions.MapSizeOfSegment, "mapSizeOfSegment", cacheOptions.MapSizeOfSegment, "The map size of segment.")
 	flag.IntVar(&cacheOptions.SegmentSize, "segmentSize", cacheOptions.SegmentSize, "The number of segment in a cache. This value should be the pow of 2 for precision.")
-	flag.IntVar(&cacheOptions.CasSleepTime, "casSleepTime", cacheOptions.CasSleepTime, "The time of sleep in one cas step. The unit is Microsecond.")
+	flag.StringVar(&cacheOptions.AofFile, "aofFile", cacheOptions.AofFile, "The file used to append every write operation. Empty means aof is disabled.")
+	flag.StringVar(&cacheOptions.AofFsync, "aofFsync", cacheOptions.AofFsync, "The fsync policy of aof (always, everysec, no).")
+	flag.Var(newByteSizeValue(&cacheOptions.AofRewriteSize), "aofRewriteSize", "The size of aof file which triggers a dump and an aof rewrite, such as 64MB.")
+	flag.StringVar(&cacheOptions.EvictionPolicy, "evictionPolicy", cacheOptions.EvictionPolicy, "The policy used to evict entries when cache is full (none, lru, lfu, fifo, tinylfu).")
+	flag.DurationVar(&cacheOptions.DefaultTTL, "defaultTtl", cacheOptions.DefaultTTL, "The ttl used when setting entries without ttl, such as 10m. Zero means never expire.")
 	flag.Parse()
 
     // 从 flag 中解析出集群信息
 	serverOptions.Cluster = nodesInCluster(*cluster)
 
 	// 使用选项配置初始化缓存
-	cache := caches.NewCacheWith(cacheOptions)
+	cache, err := caches.NewCacheWith(cacheOptions)
+	if err != nil {
+		panic(err)
+	}
 	cache.AutoGc()
 	cache.AutoDump()
 
@@ -49,10 +99,34 @@ func main() {
 	log.Printf("Using server options %+v\n", serverOptions)
 	log.Printf("Using cache options %+v\n", cacheOptions)
 	log.Printf("Kafo is running on %s at %s:%d.", serverOptions.ServerType, serverOptions.Address, serverOptions.Port)
-	err = server.Run()
-	if err != nil {
-		panic(err)
+
+	// 服务器在另外的协程中运行，主协程等待退出信号，收到信号之后优雅地关闭服务器和缓存
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- server.Run()
+	}()
+
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err = <-runErrCh:
+		if err != nil {
+			panic(err)
+		}
+	case sig := <-signalCh:
+		log.Printf("Kafo received signal %s, shutting down...", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(*shutdownTimeout)*time.Second)
+	defer cancel()
+	if err = server.Close(ctx); err != nil {
+		log.Printf("Failed to close server: %s", err.Error())
+	}
+
+	if err = cache.Close(ctx); err != nil {
+		log.Printf("Failed to close cache: %s", err.Error())
 	}
+	log.Println("Kafo is stopped.")
 }
 
 // nodesInCluster 使用 "," 分割 cluster 并解析出集群信息。
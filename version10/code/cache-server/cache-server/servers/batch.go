@@ -0,0 +1,215 @@
+package servers
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"cache-server/caches"
+)
+
+const (
+	// batchOK 表示批量操作中这个 key 处理成功了，后面跟着的是数据。
+	batchOK = byte(0)
+
+	// batchFailed 表示批量操作中这个 key 处理失败了，后面跟着的是错误信息。
+	batchFailed = byte(1)
+)
+
+var (
+	// corruptedBatchErr 是批量操作的数据格式不对时返回的错误。
+	corruptedBatchErr = errors.New("batch data is corrupted")
+)
+
+// batchResult 是批量操作中单个 key 的处理结果。
+type batchResult struct {
+
+	// Key 是这个结果对应的 key。
+	Key string `json:"key"`
+
+	// Value 是获取到的数据，只有批量获取才会有。
+	Value []byte `json:"value,omitempty"`
+
+	// Error 是处理这个 key 时发生的错误，为空表示处理成功。
+	// 不属于当前节点的 key 会返回重定向错误，客户端需要去对应的节点重新处理。
+	Error string `json:"error,omitempty"`
+}
+
+// batchResultOf 返回 key 的处理结果。
+func batchResultOf(key string, value []byte, err error) batchResult {
+	result := batchResult{Key: key, Value: value}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// batchGet 在当前节点批量获取数据，结果的顺序和 keys 一致。
+func batchGet(n *node, cache *caches.Cache, keys []string) []batchResult {
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		if err := n.checkNode(key); err != nil {
+			results[i] = batchResultOf(key, nil, err)
+			continue
+		}
+
+		value, ok := cache.Get(key)
+		if !ok {
+			results[i] = batchResultOf(key, nil, notFoundErr)
+			continue
+		}
+		results[i] = batchResultOf(key, value, nil)
+	}
+	return results
+}
+
+// batchSet 在当前节点批量添加数据，所有数据都使用同样的 ttl 和过期方式，结果的顺序和 keys 一致。
+func batchSet(n *node, cache *caches.Cache, keys []string, values [][]byte, ttl time.Duration, opts []caches.SetOption) []batchResult {
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		err := n.checkNode(key)
+		if err == nil {
+			err = cache.SetWithTTL(key, values[i], ttl, opts...)
+		}
+		results[i] = batchResultOf(key, nil, err)
+	}
+	return results
+}
+
+// batchDelete 在当前节点批量删除数据，结果的顺序和 keys 一致。
+func batchDelete(n *node, cache *caches.Cache, keys []string) []batchResult {
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		err := n.checkNode(key)
+		if err == nil {
+			err = cache.Delete(key)
+		}
+		results[i] = batchResultOf(key, nil, err)
+	}
+	return results
+}
+
+// encodeBatchResults 把批量操作的结果编码成 TCP 的响应。
+// 每个结果都是 1 个字节的状态，4 个字节大端存储的长度，然后是数据或者错误信息，顺序和请求中 key 的顺序一致，所以不需要编码 key。
+func encodeBatchResults(results []batchResult) []byte {
+	size := 0
+	for _, result := range results {
+		size += 5 + len(result.Value) + len(result.Error)
+	}
+
+	body := make([]byte, 0, size)
+	for _, result := range results {
+		status, payload := batchOK, result.Value
+		if result.Error != "" {
+			status, payload = batchFailed, []byte(result.Error)
+		}
+
+		body = append(body, status)
+		body = append(body, uint32ToBytes(uint32(len(payload)))...)
+		body = append(body, payload...)
+	}
+	return body
+}
+
+// decodeBatchResults 解码 encodeBatchResults 编码的结果，keys 是请求中 key 的顺序。
+func decodeBatchResults(body []byte, keys []string) ([]batchResult, error) {
+	results := make([]batchResult, len(keys))
+	for i, key := range keys {
+		if len(body) < 5 {
+			return nil, corruptedBatchErr
+		}
+
+		status, length := body[0], int(binary.BigEndian.Uint32(body[1:5]))
+		body = body[5:]
+		if len(body) < length {
+			return nil, corruptedBatchErr
+		}
+
+		results[i].Key = key
+		if status == batchFailed {
+			results[i].Error = string(body[:length])
+		} else {
+			results[i].Value = body[:length]
+		}
+		body = body[length:]
+	}
+	return results, nil
+}
+
+// encodeBatchKeys 把 keys 编码成二进制的列表，每个 key 前面都是 4 个字节大端存储的长度。
+func encodeBatchKeys(keys []string) []byte {
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = []byte(key)
+	}
+	return encodeBatchValues(values)
+}
+
+// encodeBatchValues 把 values 编码成二进制的列表，格式和 encodeBatchKeys 一样，可以使用 decodeBatchKeys 解码。
+func encodeBatchValues(values [][]byte) []byte {
+	size := 0
+	for _, value := range values {
+		size += 4 + len(value)
+	}
+
+	body := make([]byte, 0, size)
+	for _, value := range values {
+		body = append(body, uint32ToBytes(uint32(len(value)))...)
+		body = append(body, value...)
+	}
+	return body
+}
+
+// decodeBatchKeys 解码二进制的 key 列表，每个 key 前面都是 4 个字节大端存储的长度。
+func decodeBatchKeys(body []byte) ([][]byte, error) {
+	var keys [][]byte
+	for len(body) > 0 {
+		if len(body) < 4 {
+			return nil, corruptedBatchErr
+		}
+
+		length := int(binary.BigEndian.Uint32(body))
+		body = body[4:]
+		if len(body) < length {
+			return nil, corruptedBatchErr
+		}
+
+		keys = append(keys, body[:length])
+		body = body[length:]
+	}
+	return keys, nil
+}
+
+// encodeZMembers 把有序集合的成员编码成二进制的列表，成员和 8 个字节大端存储的分数交替出现，格式和 encodeBatchValues 一样。
+func encodeZMembers(members []caches.ZMember) []byte {
+	values := make([][]byte, 0, 2*len(members))
+	for _, member := range members {
+		values = append(values, []byte(member.Member), float64ToBytes(member.Score))
+	}
+	return encodeBatchValues(values)
+}
+
+// decodeZMembers 解码使用 encodeZMembers 编码的有序集合的成员。
+func decodeZMembers(body []byte) ([]caches.ZMember, error) {
+	values, err := decodeBatchKeys(body)
+	if err != nil || len(values)%2 != 0 {
+		return nil, corruptedBatchErr
+	}
+
+	members := make([]caches.ZMember, 0, len(values)/2)
+	for i := 0; i < len(values); i += 2 {
+		score, err := float64OfReply(values[i+1])
+		if err != nil || len(values[i+1]) != 8 {
+			return nil, corruptedBatchErr
+		}
+		members = append(members, caches.ZMember{Member: string(values[i]), Score: score})
+	}
+	return members, nil
+}
+
+// uint32ToBytes 把 n 按照大端的方式转换成 4 个字节。
+func uint32ToBytes(n uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, n)
+	return b
+}
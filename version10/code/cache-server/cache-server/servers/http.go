@@ -1,17 +1,26 @@
 package servers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"time"
 
 	"cache-server/caches"
 	"cache-server/helpers"
 	"github.com/julienschmidt/httprouter"
 )
 
+// cacheHandle 是操作缓存数据的路由处理器，cache 是请求所在的命名空间。
+type cacheHandle func(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params)
+
 // HTTPServer 是提供 http 服务的服务器。
 type HTTPServer struct {
 
@@ -24,6 +33,18 @@ type HTTPServer struct {
 
 	// options 存储着这个服务器的选项配置。
 	options *Options
+
+	// metrics 记录着这个服务器的监控数据。
+	metrics *metrics
+
+	// server 是内部真正用于服务的 http 服务器。
+	server *http.Server
+
+	// ctx 是所有请求的 context 的父 context，会在服务器关闭的时候取消，用于让阻塞的请求马上返回。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
 }
 
 // NewHTTPServer 返回一个 http 服务器。
@@ -35,16 +56,40 @@ func NewHTTPServer(cache *caches.Cache, options *Options) (*HTTPServer, error) {
 		return nil, err
 	}
 
-	return &HTTPServer{
+	hs := &HTTPServer{
 		node: n,
 		cache:   cache,
 		options: options,
-	}, nil
+		metrics: newMetrics("http"),
+	}
+
+	hs.ctx, hs.cancel = context.WithCancel(context.Background())
+	hs.server = &http.Server{
+		Addr:        helpers.JoinAddressAndPort(options.Address, options.Port),
+		Handler:     hs.routerHandler(),
+		BaseContext: func(net.Listener) context.Context { return hs.ctx },
+	}
+	return hs, nil
 }
 
-// Run 启动这个 http 服务器。
+// Run 启动这个 http 服务器，服务器被关闭之后会返回 nil。
 func (hs *HTTPServer) Run() error {
-	return http.ListenAndServe(helpers.JoinAddressAndPort(hs.options.Address, hs.options.Port), hs.routerHandler())
+	err := hs.server.ListenAndServe()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// Close 优雅地关闭这个 http 服务器。
+// 先停止接收新的请求并等待正在处理的请求处理完，然后离开集群。
+func (hs *HTTPServer) Close(ctx context.Context) error {
+	hs.cancel()
+	err := hs.server.Shutdown(ctx)
+	if leaveErr := hs.leave(ctx); err == nil {
+		err = leaveErr
+	}
+	return err
 }
 
 // =======================================================================
@@ -57,18 +102,102 @@ func wrapUriWithVersion(uri string) string {
 // routerHandler 返回注册的路由处理器。
 func (hs *HTTPServer) routerHandler() http.Handler {
 	router := httprouter.New()
-	router.GET(wrapUriWithVersion("/cache/:key"), hs.getHandler)
-	router.PUT(wrapUriWithVersion("/cache/:key"), hs.setHandler)
-	router.DELETE(wrapUriWithVersion("/cache/:key"), hs.deleteHandler)
-	router.GET(wrapUriWithVersion("/status"), hs.statusHandler)
+	hs.handleCache(router, http.MethodGet, "/cache/:key", "get", hs.getHandler)
+	hs.handleCache(router, http.MethodPut, "/cache/:key", "set", hs.setHandler)
+	hs.handleCache(router, http.MethodDelete, "/cache/:key", "delete", hs.deleteHandler)
+	hs.handleCache(router, http.MethodGet, "/status", "status", hs.statusHandler)
     
     // 这个 /nodes 路由是新加的，用于获取当前集群的所有节点名称。
-	router.GET(wrapUriWithVersion("/nodes"), hs.nodesHandler)
+	router.GET(wrapUriWithVersion("/nodes"), hs.withMetrics("nodes", hs.nodesHandler))
+
+	// 查看和修改数据有效期的路由
+	hs.handleCache(router, http.MethodGet, "/ttl/:key", "ttl", hs.ttlHandler)
+	hs.handleCache(router, http.MethodPut, "/ttl/:key", "expire", hs.expireHandler)
+	hs.handleCache(router, http.MethodDelete, "/ttl/:key", "persist", hs.persistHandler)
+	hs.handleCache(router, http.MethodPost, "/touch/:key", "touch", hs.touchHandler)
+
+	// 原子加减整数的路由
+	hs.handleCache(router, http.MethodPost, "/incr/:key", "incr", hs.incrHandler)
+	hs.handleCache(router, http.MethodPost, "/decr/:key", "decr", hs.decrHandler)
+
+	// 批量操作的路由，不属于当前节点的 key 会单独返回重定向错误
+	hs.handleCache(router, http.MethodPost, "/batch/get", "mget", hs.batchGetHandler)
+	hs.handleCache(router, http.MethodPost, "/batch/set", "mset", hs.batchSetHandler)
+	hs.handleCache(router, http.MethodPost, "/batch/delete", "mdelete", hs.batchDeleteHandler)
+
+	// 遍历和批量删除 key 的路由，只会作用于当前节点
+	hs.handleCache(router, http.MethodGet, "/keys", "scan", hs.keysHandler)
+	hs.handleCache(router, http.MethodDelete, "/keys", "deleteKeys", hs.deleteKeysHandler)
+
+	// 哈希类型的路由，删除整个哈希直接使用 DELETE /cache/:key
+	hs.handleCache(router, http.MethodGet, "/hash/:key", "hgetAll", hs.hgetAllHandler)
+	hs.handleCache(router, http.MethodPut, "/hash/:key", "hset", hs.hsetHandler)
+	hs.handleCache(router, http.MethodGet, "/hash/:key/:field", "hget", hs.hgetHandler)
+	hs.handleCache(router, http.MethodPut, "/hash/:key/:field", "hset", hs.hsetHandler)
+	hs.handleCache(router, http.MethodDelete, "/hash/:key/:field", "hdel", hs.hdelHandler)
+
+	// 列表类型的路由，删除整个列表直接使用 DELETE /cache/:key
+	hs.handleCache(router, http.MethodGet, "/list/:key", "lrange", hs.lrangeHandler)
+	hs.handleCache(router, http.MethodPost, "/list/:key/lpush", "lpush", hs.lpushHandler)
+	hs.handleCache(router, http.MethodPost, "/list/:key/rpush", "rpush", hs.rpushHandler)
+	hs.handleCache(router, http.MethodPost, "/list/:key/lpop", "lpop", hs.lpopHandler)
+	hs.handleCache(router, http.MethodPost, "/list/:key/rpop", "rpop", hs.rpopHandler)
+	hs.handleCache(router, http.MethodPost, "/list/:key/trim", "ltrim", hs.ltrimHandler)
+
+	// 集合类型的路由，删除整个集合直接使用 DELETE /cache/:key
+	hs.handleCache(router, http.MethodGet, "/set/:key", "smembers", hs.smembersHandler)
+	hs.handleCache(router, http.MethodGet, "/set/:key/:member", "sismember", hs.sismemberHandler)
+	hs.handleCache(router, http.MethodPut, "/set/:key/:member", "sadd", hs.saddHandler)
+	hs.handleCache(router, http.MethodDelete, "/set/:key/:member", "srem", hs.sremHandler)
+
+	// 有序集合类型的路由，删除整个有序集合直接使用 DELETE /cache/:key
+	hs.handleCache(router, http.MethodGet, "/zset/:key", "zrange", hs.zrangeHandler)
+	hs.handleCache(router, http.MethodGet, "/zset/:key/:member", "zscore", hs.zscoreHandler)
+	hs.handleCache(router, http.MethodPut, "/zset/:key/:member", "zadd", hs.zaddHandler)
+	hs.handleCache(router, http.MethodDelete, "/zset/:key/:member", "zrem", hs.zremHandler)
+	hs.handleCache(router, http.MethodPost, "/zset/:key/:member/incr", "zincrBy", hs.zincrByHandler)
+
+	// 管理相关的路由，只会作用于当前节点，需要作用于整个集群的话要对每个节点都调用一次
+	router.POST(wrapUriWithVersion("/admin/dump"), hs.withMetrics("dump", hs.dumpHandler))
+	router.POST(wrapUriWithVersion("/admin/restore"), hs.withMetrics("restore", hs.restoreHandler))
+	hs.handleCache(router, http.MethodPost, "/admin/flush", "flush", hs.flushHandler)
+
+	// 命名空间相关的路由，命名空间需要在每个节点上分别创建和删除
+	router.GET(wrapUriWithVersion("/ns"), hs.withMetrics("namespaces", hs.namespacesHandler))
+	router.PUT(wrapUriWithVersion("/ns/:ns"), hs.withMetrics("createNamespace", hs.createNamespaceHandler))
+	router.DELETE(wrapUriWithVersion("/ns/:ns"), hs.withMetrics("dropNamespace", hs.dropNamespaceHandler))
+
+	// /metrics 是给 Prometheus 抓取监控数据用的，按照惯例不加 API 版本
+	router.Handler(http.MethodGet, "/metrics", hs.metrics.handler(hs.cache, hs.node))
 	return router
 }
 
+// handleCache 注册操作缓存数据的路由，同时注册一个以 /ns/:ns 开头的路由，用于在命名空间中执行，比如 /v1/ns/:ns/cache/:key。
+func (hs *HTTPServer) handleCache(router *httprouter.Router, method string, uri string, command string, handler cacheHandle) {
+	router.Handle(method, wrapUriWithVersion(uri), hs.withMetrics(command, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		handler(hs.cache, writer, request, params)
+	}))
+
+	router.Handle(method, wrapUriWithVersion("/ns/:ns"+uri), hs.withMetrics(command, func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		namespace, ok := hs.cache.Namespace(params.ByName("ns"))
+		if !ok {
+			writeErrorResponse(writer, http.StatusNotFound, caches.NamespaceNotFoundErr)
+			return
+		}
+		handler(namespace, writer, request, params)
+	}))
+}
+
+// withMetrics 包装 handler，记录每次请求的耗时。
+func (hs *HTTPServer) withMetrics(command string, handler httprouter.Handle) httprouter.Handle {
+	return func(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+		defer hs.metrics.observe(command, time.Now())
+		handler(writer, request, params)
+	}
+}
+
 // getHandler 获取缓存中的数据并返回。
-func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+func (hs *HTTPServer) getHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
     // 使用一致性哈希选择出这个 key 所属的物理节点
 	key := params.ByName("key")
@@ -86,16 +215,24 @@ func (hs *HTTPServer) getHandler(writer http.ResponseWriter, request *http.Reque
 	}
 
     // 当前节点处理
-	value, ok := hs.cache.Get(key)
+	value, version, ok := cache.GetWithVersion(key)
 	if !ok {
 		writer.WriteHeader(http.StatusNotFound)
 		return
 	}
+
+	// 数据的版本号作为 ETag 返回，客户端带上的 If-None-Match 和它一致的话说明数据没有变化，返回 304 就可以了
+	etag := etagOf(version)
+	writer.Header().Set("ETag", etag)
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch == etag || ifNoneMatch == "*" {
+		writer.WriteHeader(http.StatusNotModified)
+		return
+	}
 	writer.Write(value)
 }
 
 // setHandler 添加数据到缓存中。
-func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+func (hs *HTTPServer) setHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
 
     // 使用一致性哈希选择出这个 key 所属的物理节点
 	key := params.ByName("key")
@@ -119,15 +256,46 @@ func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-    // 从请求中获取 ttl
+    // 从请求中获取 ttl 和过期时间点，解析出错说明请求有问题，返回 400 错误码
 	ttl, err := ttlOf(request)
 	if err != nil {
-		writer.WriteHeader(http.StatusInternalServerError)
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	expireAt, hasExpireAt, err := expireAtOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+    // 带上了 If-Match 或者 If-None-Match 头部的话，需要满足条件才添加数据
+	ifMatch, ifNoneMatch := request.Header.Get("If-Match"), request.Header.Get("If-None-Match")
+	if ifMatch != "" || ifNoneMatch != "" {
+		if hasExpireAt {
+			// 已经过去的时间点相当于马上过期
+			ttl, opts = time.Until(expireAt), nil
+			if ttl <= 0 {
+				ttl = time.Nanosecond
+			}
+		}
+		hs.conditionalSet(cache, writer, key, value, ifMatch, ifNoneMatch, ttl, opts)
 		return
 	}
 
-    // 添加数据，并设置为指定的 ttl
-	err = hs.cache.SetWithTTL(key, value, ttl)
+    // 添加数据，设置了过期时间点的话就在这个时间点过期，否则按照过期方式设置为指定的 ttl
+	if hasExpireAt {
+		err = cache.SetWithExpireAt(key, value, expireAt)
+	} else {
+		err = cache.SetWithTTL(key, value, ttl, opts...)
+	}
+
 	if err != nil {
         // 如果返回了错误，说明触发了写满保护机制，返回 413 错误码，这个错误码表示请求体中的数据太大了
         // 同时返回错误信息，加上一个 "Error: " 的前缀，方便识别为错误码
@@ -139,19 +307,113 @@ func (hs *HTTPServer) setHandler(writer http.ResponseWriter, request *http.Reque
 	writer.WriteHeader(http.StatusCreated)
 }
 
+// conditionalSet 按照 If-Match 和 If-None-Match 头部的条件添加数据，成功的话返回 201 和新数据的 ETag，条件不满足返回 412 错误码。
+// If-None-Match 为 * 表示只在数据不存在时添加，If-Match 为 * 表示只在数据存在时添加，If-Match 为 ETag 表示只在数据的版本号一致时添加。
+func (hs *HTTPServer) conditionalSet(cache *caches.Cache, writer http.ResponseWriter, key string, value []byte, ifMatch string, ifNoneMatch string, ttl time.Duration, opts []caches.SetOption) {
+	var version uint64
+	var err error
+	switch {
+	case ifNoneMatch == "*" && ifMatch == "":
+		version, err = cache.SetIfAbsent(key, value, ttl, opts...)
+	case ifMatch == "*" && ifNoneMatch == "":
+		version, err = cache.SetIfPresent(key, value, ttl, opts...)
+	case ifMatch != "" && ifNoneMatch == "":
+		expectedVersion, parseErr := versionOfETag(ifMatch)
+		if parseErr != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, parseErr)
+			return
+		}
+		version, err = cache.CompareAndSet(key, value, expectedVersion, ttl, opts...)
+	default:
+		writeErrorResponse(writer, http.StatusBadRequest, errors.New("unsupported combination of If-Match and If-None-Match"))
+		return
+	}
+
+	if err == caches.VersionMismatchErr || err == caches.KeyExistsErr || err == caches.KeyNotFoundErr {
+		writeErrorResponse(writer, http.StatusPreconditionFailed, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writer.Header().Set("ETag", etagOf(version))
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// etagOf 返回版本号对应的 ETag，按照 HTTP 的规范需要加上双引号。
+func etagOf(version uint64) string {
+	return strconv.Quote(strconv.FormatUint(version, 10))
+}
+
+// versionOfETag 从 ETag 中解析出版本号，弱 ETag 的 W/ 前缀会被忽略。
+func versionOfETag(etag string) (uint64, error) {
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), "\"")
+	return strconv.ParseUint(etag, 10, 64)
+}
+
 // ttlOf 从请求中解析 ttl 并返回，如果 error 不为空，说明 ttl 解析出错。
-func ttlOf(request *http.Request) (int64, error) {
+// HTTP 接口中所有的时间长度和时间点都使用秒作为单位，ttl 也可以带上单位，比如 250ms、1.5s 和 1h，没有单位的话按照秒处理。
+// 没有 Ttl 头部的话使用默认有效期，-1 表示永不过期。
+func ttlOf(request *http.Request) (time.Duration, error) {
     
     // 从请求头中获取 ttl 头部，如果没有设置或者 ttl 为空均按不设置 ttl 处理，也就是不会过期
 	ttls, ok := request.Header["Ttl"]
 	if !ok || len(ttls) < 1 {
 		return caches.NeverDie, nil
 	}
-	return strconv.ParseInt(ttls[0], 10, 64)
+
+	// 和 ttl 接口返回的 -1 一样，-1 表示永不过期，即使命名空间设置了默认有效期
+	if strings.TrimSpace(ttls[0]) == "-1" {
+		return caches.Forever, nil
+	}
+	return helpers.ParseDuration(ttls[0], time.Second)
+}
+
+// setOptionsOfRequest 从请求的 Ttl-Mode、Ttl-Max-Age 和 Tags 头部中解析过期方式和标签。
+// Ttl-Mode 可以是 absolute 或者 sliding，默认是 absolute，Ttl-Max-Age 是最长寿命，和 Ttl 一样可以带上单位。
+// Tags 是使用逗号分隔的标签列表，比如 user:1,profile。
+func setOptionsOfRequest(request *http.Request) ([]caches.SetOption, error) {
+	maxAge := time.Duration(0)
+	if maxAgeHeader := request.Header.Get("Ttl-Max-Age"); maxAgeHeader != "" {
+		var err error
+		maxAge, err = helpers.ParseDuration(maxAgeHeader, time.Second)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var tags []string
+	for _, tag := range strings.Split(request.Header.Get("Tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return setOptionsOf(request.Header.Get("Ttl-Mode"), maxAge, tags)
+}
+
+// expireAtOf 从请求的 Expire-At 头部中解析过期的时间点，第二个返回值表示请求是否设置了过期时间点。
+// 过期时间点可以是 Unix 时间戳，单位和其他 HTTP 接口一样是秒，可以带上小数，比如 1700000000.25，
+// 也可以是 RFC3339 格式的时间，比如 2006-01-02T15:04:05.999Z。
+func expireAtOf(request *http.Request) (time.Time, bool, error) {
+	expireAt := request.Header.Get("Expire-At")
+	if expireAt == "" {
+		return time.Time{}, false, nil
+	}
+
+	if seconds, err := strconv.ParseFloat(expireAt, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) || math.Abs(seconds) >= math.MaxInt64/float64(time.Second) {
+			return time.Time{}, true, errors.New("invalid expire at " + expireAt)
+		}
+		return time.Unix(0, int64(seconds*float64(time.Second))), true, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, expireAt)
+	return t, true, err
 }
 
 // deleteHandler 从缓存中删除指定数据。
-func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+func (hs *HTTPServer) deleteHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
     
     // 使用一致性哈希选择出这个 key 所属的物理节点
 	key := params.ByName("key")
@@ -169,7 +431,7 @@ func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, request *http.Re
 	}
 
     // 当前节点处理
-	err = hs.cache.Delete(key)
+	err = cache.Delete(key)
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
@@ -177,8 +439,8 @@ func (hs *HTTPServer) deleteHandler(writer http.ResponseWriter, request *http.Re
 }
 
 // statusHandler 返回缓存信息。
-func (hs *HTTPServer) statusHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
-	status, err := json.Marshal(hs.cache.Status())
+func (hs *HTTPServer) statusHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	status, err := json.Marshal(cache.Status())
 	if err != nil {
 		writer.WriteHeader(http.StatusInternalServerError)
 		return
@@ -195,3 +457,1079 @@ func (hs *HTTPServer) nodesHandler(writer http.ResponseWriter, request *http.Req
 	}
 	writer.Write(nodes)
 }
+
+// redirectIfNeeded 判断 key 所属的物理节点是否是当前节点，如果不是，就响应重定向信息给客户端，并告知正确的节点地址。
+// 返回 true 表示已经响应过了，调用者不需要再处理这个请求。
+func (hs *HTTPServer) redirectIfNeeded(writer http.ResponseWriter, request *http.Request, key string) bool {
+	node, err := hs.selectNode(key)
+	if err != nil {
+		writer.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+
+	if !hs.isCurrentNode(node) {
+		writer.Header().Set("Location", node+request.RequestURI)
+		writer.WriteHeader(http.StatusTemporaryRedirect)
+		return true
+	}
+	return false
+}
+
+// ttlHandler 返回数据还剩多长时间过期，单位和其他 HTTP 接口一样是秒，保留 3 位小数，永不过期的数据返回 -1。
+func (hs *HTTPServer) ttlHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	ttl, ok := cache.TTL(key)
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if ttl == caches.NeverDie {
+		writer.Write([]byte("-1"))
+		return
+	}
+	writer.Write([]byte(strconv.FormatFloat(ttl.Seconds(), 'f', 3, 64)))
+}
+
+// expireHandler 把数据设置为从现在开始 Ttl 头部指定的时间之后过期，Ttl 可以带上单位，没有单位的话按照秒处理。
+func (hs *HTTPServer) expireHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	if request.Header.Get("Ttl") == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	ok, err := cache.Expire(key, ttl)
+	writeUpdateResponse(writer, ok, err)
+}
+
+// persistHandler 移除数据的所有过期设置，让它永不过期。
+func (hs *HTTPServer) persistHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	ok, err := cache.Persist(key)
+	writeUpdateResponse(writer, ok, err)
+}
+
+// touchHandler 更新数据的访问时间，空闲过期的数据会重新计算空闲时间。
+func (hs *HTTPServer) touchHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	ok, err := cache.Touch(key)
+	writeUpdateResponse(writer, ok, err)
+}
+
+// writeUpdateResponse 根据修改数据的结果进行响应，出错返回 500，数据不存在返回 404，成功的话返回 200。
+func writeUpdateResponse(writer http.ResponseWriter, ok bool, err error) {
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// incrHandler 把指定 key 的整数加上 Delta 头部指定的数，没有 Delta 头部的话就加 1，并返回加完之后的结果。
+// key 不存在的话会创建这个数据，这时候会使用 Ttl、Ttl-Mode 和 Ttl-Max-Age 头部设置有效期。
+func (hs *HTTPServer) incrHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.incrBy(cache, writer, request, params.ByName("key"), 1)
+}
+
+// decrHandler 把指定 key 的整数减去 Delta 头部指定的数，没有 Delta 头部的话就减 1，并返回减完之后的结果。
+func (hs *HTTPServer) decrHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.incrBy(cache, writer, request, params.ByName("key"), -1)
+}
+
+// incrBy 把指定 key 的整数加上 sign 乘以 Delta 头部指定的数，数据不是整数或者结果溢出的话返回 409 错误码。
+func (hs *HTTPServer) incrBy(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, key string, sign int64) {
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	delta := int64(1)
+	if deltaHeader := request.Header.Get("Delta"); deltaHeader != "" {
+		var err error
+		delta, err = strconv.ParseInt(deltaHeader, 10, 64)
+		if err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := cache.IncrBy(key, sign*delta, ttl, opts...)
+	if err == caches.NotIntegerErr || err == caches.IntegerOverflowErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writer.Write([]byte(strconv.FormatInt(result, 10)))
+}
+
+// batchGetHandler 批量获取数据，请求体是 key 列表，返回 JSON 格式的结果列表，顺序和 key 列表一致。
+func (hs *HTTPServer) batchGetHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	keys, err := batchKeysOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeBatchResponse(writer, batchGet(hs.node, cache, keys))
+}
+
+// batchSetHandler 批量添加数据，所有数据都使用 Ttl、Ttl-Mode 和 Ttl-Max-Age 头部指定的过期设置。
+// 请求体是 JSON 格式的 [{"key": "k", "value": "base64"}] 列表，或者是二进制的列表，key 和 value 交替出现。
+func (hs *HTTPServer) batchSetHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	keys, values, err := batchEntriesOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeBatchResponse(writer, batchSet(hs.node, cache, keys, values, ttl, opts))
+}
+
+// batchDeleteHandler 批量删除数据，请求体是 key 列表，返回 JSON 格式的结果列表，顺序和 key 列表一致。
+func (hs *HTTPServer) batchDeleteHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	keys, err := batchKeysOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+	writeBatchResponse(writer, batchDelete(hs.node, cache, keys))
+}
+
+// isBinaryRequest 判断请求体是不是二进制格式，否则就是 JSON 格式。
+func isBinaryRequest(request *http.Request) bool {
+	return request.Header.Get("Content-Type") == "application/octet-stream"
+}
+
+// batchKeysOf 从请求体中解析出 key 列表。
+// 请求体可以是 JSON 格式的字符串数组，也可以是 Content-Type 为 application/octet-stream 的二进制列表，每个 key 前面都是 4 个字节大端存储的长度。
+func batchKeysOf(request *http.Request) ([]string, error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinaryRequest(request) {
+		keys, err := decodeBatchKeys(body)
+		return stringsOf(keys), err
+	}
+
+	var keys []string
+	err = json.Unmarshal(body, &keys)
+	return keys, err
+}
+
+// batchEntriesOf 从请求体中解析出需要添加的 key 和 value 列表。
+func batchEntriesOf(request *http.Request) ([]string, [][]byte, error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if isBinaryRequest(request) {
+		entries, err := decodeBatchKeys(body)
+		if err != nil || len(entries)%2 != 0 {
+			return nil, nil, corruptedBatchErr
+		}
+
+		keys := make([]string, 0, len(entries)/2)
+		values := make([][]byte, 0, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			keys = append(keys, string(entries[i]))
+			values = append(values, entries[i+1])
+		}
+		return keys, values, nil
+	}
+
+	var entries []struct {
+		Key   string `json:"key"`
+		Value []byte `json:"value"`
+	}
+
+	if err = json.Unmarshal(body, &entries); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make([]string, len(entries))
+	values := make([][]byte, len(entries))
+	for i, entry := range entries {
+		keys[i], values[i] = entry.Key, entry.Value
+	}
+	return keys, values, nil
+}
+
+// writeBatchResponse 把批量操作的结果以 JSON 格式返回，value 会被编码成 base64。
+func writeBatchResponse(writer http.ResponseWriter, results []batchResult) {
+	body, err := json.Marshal(results)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// hgetAllHandler 返回哈希中所有的字段，格式是 JSON 对象，字段值会被编码成 base64，key 不存在的话返回空的对象。
+func (hs *HTTPServer) hgetAllHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	hash, err := cache.HGetAll(key)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	body, err := json.Marshal(hash)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// hgetHandler 返回哈希中一个字段的数据，字段不存在的话返回 404 错误码。
+func (hs *HTTPServer) hgetHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	data, ok, err := cache.HGet(key, params.ByName("field"))
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.Write(data)
+}
+
+// hsetHandler 设置哈希中的字段，返回新增的字段个数，key 不存在的时候会使用 Ttl、Ttl-Mode、Ttl-Max-Age 和 Tags 头部创建哈希。
+// 路由中带有字段名的话，请求体就是这个字段的数据，否则请求体是所有的字段，格式和 hgetAllHandler 返回的一样，
+// 也可以使用 application/octet-stream 类型的二进制格式，也就是 encodeBatchKeys 编码的交替出现的字段名和字段值。
+func (hs *HTTPServer) hsetHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	fields, err := hashFieldsOf(request, params.ByName("field"))
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	added, err := cache.HSet(key, fields, ttl, opts...)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(added)))
+}
+
+// hashFieldsOf 从请求体中解析出需要设置的字段，field 不为空的话请求体就是这个字段的数据。
+func hashFieldsOf(request *http.Request, field string) (map[string][]byte, error) {
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if field != "" {
+		return map[string][]byte{field: body}, nil
+	}
+
+	if isBinaryRequest(request) {
+		entries, err := decodeBatchKeys(body)
+		if err != nil || len(entries)%2 != 0 {
+			return nil, corruptedBatchErr
+		}
+
+		fields := make(map[string][]byte, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			fields[string(entries[i])] = entries[i+1]
+		}
+		return fields, nil
+	}
+
+	var fields map[string][]byte
+	err = json.Unmarshal(body, &fields)
+	return fields, err
+}
+
+// hdelHandler 删除哈希中的一个字段，返回实际删除的字段个数，所有字段都被删除的话 key 也会被删除。
+func (hs *HTTPServer) hdelHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	deleted, err := cache.HDel(key, params.ByName("field"))
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(deleted)))
+}
+
+// lrangeHandler 返回列表中 start 到 stop 参数之间的元素，包括 stop，格式是 JSON 数组，元素会被编码成 base64。
+// 负数的下标表示从尾部开始数，默认返回所有的元素，key 不存在的话返回空的数组。
+func (hs *HTTPServer) lrangeHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	start, stop, err := listRangeOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	list, err := cache.LRange(key, start, stop)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// listRangeOfRequest 从请求的 start 和 stop 参数中解析出列表的范围，默认是 0 到 -1，也就是所有的元素。
+func listRangeOfRequest(request *http.Request) (int, int, error) {
+	query := request.URL.Query()
+	start, stop := 0, -1
+	if startParam := query.Get("start"); startParam != "" {
+		var err error
+		if start, err = strconv.Atoi(startParam); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if stopParam := query.Get("stop"); stopParam != "" {
+		var err error
+		if stop, err = strconv.Atoi(stopParam); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, stop, nil
+}
+
+// lpushHandler 把请求体作为一个元素插入到列表头部，返回插入之后列表的长度。
+// key 不存在的时候会使用 Ttl、Ttl-Mode、Ttl-Max-Age 和 Tags 头部创建列表。
+func (hs *HTTPServer) lpushHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.push(writer, request, params.ByName("key"), cache.LPush)
+}
+
+// rpushHandler 把请求体作为一个元素追加到列表尾部，其他和 lpushHandler 一样。
+func (hs *HTTPServer) rpushHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.push(writer, request, params.ByName("key"), cache.RPush)
+}
+
+// push 使用 push 把请求体作为一个元素插入到列表中，key 不是列表的话返回 409 错误码。
+func (hs *HTTPServer) push(writer http.ResponseWriter, request *http.Request, key string, push func(key string, values [][]byte, ttl time.Duration, opts ...caches.SetOption) (int, error)) {
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	value, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	length, err := push(key, [][]byte{value}, ttl, opts...)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(length)))
+}
+
+// lpopHandler 弹出列表头部的元素，列表为空的话返回 404 错误码。
+// 带上 timeout 参数的话，列表为空时会一直等待，直到有新的元素或者超时，比如 /v1/list/jobs/lpop?timeout=30s，0 表示一直等待。
+func (hs *HTTPServer) lpopHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.pop(writer, request, params.ByName("key"), cache.LPop, cache.BLPop)
+}
+
+// rpopHandler 弹出列表尾部的元素，其他和 lpopHandler 一样。
+func (hs *HTTPServer) rpopHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.pop(writer, request, params.ByName("key"), cache.RPop, cache.BRPop)
+}
+
+// pop 弹出列表中的元素，有 timeout 参数的话使用 blockingPop 阻塞地弹出，否则使用 pop 弹出。
+func (hs *HTTPServer) pop(writer http.ResponseWriter, request *http.Request, key string, pop func(key string) ([]byte, bool, error), blockingPop func(ctx context.Context, key string) ([]byte, error)) {
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	timeoutParam, blocking := request.URL.Query()["timeout"]
+	if !blocking {
+		data, ok, err := pop(key)
+		writePopResponse(writer, data, ok, err)
+		return
+	}
+
+	timeout, err := helpers.ParseDuration(timeoutParam[0], time.Second)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	// 请求的 context 会在客户端断开连接或者服务器关闭的时候取消
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	data, err := blockingPop(ctx, key)
+	if err == context.DeadlineExceeded || err == context.Canceled {
+		writePopResponse(writer, nil, false, nil)
+		return
+	}
+	writePopResponse(writer, data, err == nil, err)
+}
+
+// writePopResponse 返回弹出的元素，没有弹出元素的话返回 404 错误码，key 不是列表的话返回 409 错误码。
+func writePopResponse(writer http.ResponseWriter, data []byte, ok bool, err error) {
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+	writer.Write(data)
+}
+
+// ltrimHandler 只保留列表中 start 到 stop 参数之间的元素，参数的规则和 lrangeHandler 一样，没有元素保留下来的话 key 也会被删除。
+func (hs *HTTPServer) ltrimHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	start, stop, err := listRangeOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	err = cache.LTrim(key, start, stop)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// smembersHandler 返回集合中所有的成员，格式是按照字典序排序的 JSON 数组，key 不存在的话返回空的数组。
+func (hs *HTTPServer) smembersHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	members, err := cache.SMembers(key)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	body, err := json.Marshal(members)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// sismemberHandler 判断成员是否在集合中，在的话返回 200 状态码，不在的话返回 404 错误码。
+func (hs *HTTPServer) sismemberHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	ok, err := cache.SIsMember(key, params.ByName("member"))
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if !ok {
+		writer.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// saddHandler 把路由中的成员添加到集合中，返回新增的成员个数，key 不存在的时候会使用 Ttl、Ttl-Mode、Ttl-Max-Age 和 Tags 头部创建集合。
+func (hs *HTTPServer) saddHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	added, err := cache.SAdd(key, []string{params.ByName("member")}, ttl, opts...)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(added)))
+}
+
+// sremHandler 从集合中移除路由中的成员，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (hs *HTTPServer) sremHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.remove(writer, request, params.ByName("key"), params.ByName("member"), cache.SRem)
+}
+
+// remove 使用 remove 移除路由中的成员，key 的类型不对的话返回 409 错误码。
+func (hs *HTTPServer) remove(writer http.ResponseWriter, request *http.Request, key string, member string, remove func(key string, members ...string) (int, error)) {
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	removed, err := remove(key, member)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(removed)))
+}
+
+// zrangeHandler 返回有序集合中的成员，格式是 JSON 数组，每个元素包含 member 和 score，key 不存在的话返回空的数组。
+// 带上 min 或者 max 参数的话按照分数查询，包括 min 和 max，比如 /v1/zset/board?min=60&max=100，不传的那个参数表示不限制。
+// 否则按照排名查询，参数的规则和 lrangeHandler 一样，带上 rev=true 参数的话按照分数从大到小排名，比如 /v1/zset/board?stop=9&rev=true。
+func (hs *HTTPServer) zrangeHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	members, err := zrangeOfRequest(cache, key, request)
+	if err == caches.WrongTypeErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	body, err := json.Marshal(members)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// zrangeOfRequest 根据请求的参数选择按照分数还是按照排名查询有序集合中的成员。
+func zrangeOfRequest(cache *caches.Cache, key string, request *http.Request) ([]caches.ZMember, error) {
+	query := request.URL.Query()
+	minParam, maxParam := query.Get("min"), query.Get("max")
+	if minParam != "" || maxParam != "" {
+		min, max := math.Inf(-1), math.Inf(1)
+		var err error
+		if minParam != "" {
+			if min, err = strconv.ParseFloat(minParam, 64); err != nil {
+				return nil, err
+			}
+		}
+
+		if maxParam != "" {
+			if max, err = strconv.ParseFloat(maxParam, 64); err != nil {
+				return nil, err
+			}
+		}
+		return cache.ZRangeByScore(key, min, max)
+	}
+
+	start, stop, err := listRangeOfRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	reverse, err := reverseOfRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	if reverse {
+		return cache.ZRevRange(key, start, stop)
+	}
+	return cache.ZRange(key, start, stop)
+}
+
+// reverseOfRequest 返回请求的 rev 参数，没有这个参数的话返回 false。
+func reverseOfRequest(request *http.Request) (bool, error) {
+	reverseParam := request.URL.Query().Get("rev")
+	if reverseParam == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(reverseParam)
+}
+
+// zsetMemberResponse 是查询有序集合中一个成员的返回结果，包括成员的分数和排名。
+type zsetMemberResponse struct {
+	caches.ZMember
+	Rank int `json:"rank"`
+}
+
+// zscoreHandler 返回有序集合中一个成员的分数和排名，格式是 JSON 对象，成员不存在的话返回 404 错误码。
+// 排名默认按照分数从小到大，带上 rev=true 参数的话按照分数从大到小，适合查询排行榜中的名次。
+func (hs *HTTPServer) zscoreHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	reverse, err := reverseOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	member := params.ByName("member")
+	score, ok, err := cache.ZScore(key, member)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	zrank := cache.ZRank
+	if reverse {
+		zrank = cache.ZRevRank
+	}
+
+	// 查询分数和排名之间成员可能被移除了，这时候也当作不存在
+	rank, rankOk, err := zrank(key, member)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if !ok || !rankOk {
+		writer.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(zsetMemberResponse{ZMember: caches.ZMember{Member: member, Score: score}, Rank: rank})
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// zaddHandler 把路由中的成员添加到有序集合中，请求体是成员的分数，已经存在的成员会更新分数，返回新增的成员个数。
+// key 不存在的时候会使用 Ttl、Ttl-Mode、Ttl-Max-Age 和 Tags 头部创建有序集合。
+func (hs *HTTPServer) zaddHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	score, err := strconv.ParseFloat(strings.TrimSpace(string(body)), 64)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	added, err := cache.ZAdd(key, map[string]float64{params.ByName("member"): score}, ttl, opts...)
+	if err != nil {
+		writeZSetErrorResponse(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(added)))
+}
+
+// zincrByHandler 把有序集合中路由中的成员的分数加上 Delta 头部指定的数，没有 Delta 头部的话就加 1，并返回加完之后的分数。
+// key 不存在的时候会使用 Ttl、Ttl-Mode、Ttl-Max-Age 和 Tags 头部创建有序集合。
+func (hs *HTTPServer) zincrByHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	key := params.ByName("key")
+	if hs.redirectIfNeeded(writer, request, key) {
+		return
+	}
+
+	delta := float64(1)
+	if deltaHeader := request.Header.Get("Delta"); deltaHeader != "" {
+		var err error
+		delta, err = strconv.ParseFloat(deltaHeader, 64)
+		if err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	ttl, err := ttlOf(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	opts, err := setOptionsOfRequest(request)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	score, err := cache.ZIncrBy(key, params.ByName("member"), delta, ttl, opts...)
+	if err != nil {
+		writeZSetErrorResponse(writer, err)
+		return
+	}
+	writer.Write([]byte(strconv.FormatFloat(score, 'g', -1, 64)))
+}
+
+// writeZSetErrorResponse 返回修改有序集合时发生的错误，类型不对的话返回 409 错误码，分数不是数字的话返回 400 错误码。
+func writeZSetErrorResponse(writer http.ResponseWriter, err error) {
+	switch err {
+	case caches.WrongTypeErr:
+		writeErrorResponse(writer, http.StatusConflict, err)
+	case caches.InvalidScoreErr:
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+	default:
+		writeErrorResponse(writer, http.StatusRequestEntityTooLarge, err)
+	}
+}
+
+// zremHandler 从有序集合中移除路由中的成员，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (hs *HTTPServer) zremHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	hs.remove(writer, request, params.ByName("key"), params.ByName("member"), cache.ZRem)
+}
+
+// keysHandler 遍历当前节点匹配 match 参数的 key，返回 JSON 格式的 key 列表和下一次遍历使用的 cursor，cursor 为 0 表示遍历完了。
+// 参数有 cursor、match 和 count，第一次遍历不需要传 cursor，match 使用 path.Match 的语法，比如 /v1/keys?match=user:*。
+func (hs *HTTPServer) keysHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	query := request.URL.Query()
+	cursor := uint64(0)
+	if cursorParam := query.Get("cursor"); cursorParam != "" {
+		var err error
+		cursor, err = strconv.ParseUint(cursorParam, 10, 64)
+		if err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	count := defaultScanCount
+	if countParam := query.Get("count"); countParam != "" {
+		var err error
+		count, err = strconv.Atoi(countParam)
+		if err != nil {
+			writeErrorResponse(writer, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	keys, cursor, err := cache.Scan(cursor, query.Get("match"), count)
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if keys == nil {
+		keys = []string{}
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"cursor": cursor, "keys": keys})
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(body)
+}
+
+// deleteKeysHandler 删除当前节点所有以 prefix 参数开头或者带有 tag 参数这个标签的数据，返回删除的数量。
+// prefix 和 tag 只能使用其中一个，并且不能为空，清空所有数据请使用 /v1/admin/flush。
+func (hs *HTTPServer) deleteKeysHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	query := request.URL.Query()
+	prefix, tag := query.Get("prefix"), query.Get("tag")
+	if (prefix == "") == (tag == "") {
+		writeErrorResponse(writer, http.StatusBadRequest, errors.New("either prefix or tag is required"))
+		return
+	}
+
+	var deleted int
+	var err error
+	if prefix != "" {
+		deleted, err = cache.DeleteByPrefix(prefix)
+	} else {
+		deleted, err = cache.DeleteByTag(tag)
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Write([]byte(strconv.Itoa(deleted)))
+}
+
+// dumpHandler 马上持久化当前节点的缓存数据。
+func (hs *HTTPServer) dumpHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if err := hs.cache.Dump(); err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// restoreHandler 使用 path 参数对应的历史快照替换掉当前节点的缓存数据。
+// path 是历史快照文件名中的时间戳或者快照的文件名，只能是持久化文件所在目录中的快照，其他的路径返回 400 错误码。
+func (hs *HTTPServer) restoreHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	path := request.URL.Query().Get("path")
+	if path == "" {
+		writer.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err := hs.cache.Restore(path)
+	if err == caches.InvalidSnapshotErr {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// flushHandler 清空当前节点的缓存数据。
+func (hs *HTTPServer) flushHandler(cache *caches.Cache, writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	if err := cache.Flush(); err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// namespaceRequest 是创建命名空间的请求体，和 caches.NamespaceOptions 一样，只是默认有效期使用 10m 这样的字符串。
+type namespaceRequest struct {
+	MaxEntrySize   int64  `json:"maxEntrySize"`
+	DefaultTTL     string `json:"defaultTtl"`
+	EvictionPolicy string `json:"evictionPolicy"`
+	SegmentSize    int    `json:"segmentSize"`
+}
+
+// namespacesHandler 返回当前节点所有命名空间的配置。
+func (hs *HTTPServer) namespacesHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	namespaces, err := json.Marshal(hs.cache.Namespaces())
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(namespaces)
+}
+
+// createNamespaceHandler 使用 JSON 格式的请求体在当前节点创建命名空间，命名空间已经存在的话返回 409 错误码。
+// 默认有效期可以是 10m 这样的字符串，没有单位的话按照秒处理。
+func (hs *HTTPServer) createNamespaceHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	var nsRequest namespaceRequest
+	if body, err := ioutil.ReadAll(request.Body); err != nil || len(body) > 0 && json.Unmarshal(body, &nsRequest) != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, errors.New("invalid namespace options"))
+		return
+	}
+
+	nsOptions := caches.NamespaceOptions{
+		MaxEntrySize:   nsRequest.MaxEntrySize,
+		EvictionPolicy: nsRequest.EvictionPolicy,
+		SegmentSize:    nsRequest.SegmentSize,
+	}
+
+	if nsRequest.DefaultTTL != "" {
+		defaultTTL, err := helpers.ParseDuration(nsRequest.DefaultTTL, time.Second)
+		if err != nil || defaultTTL < 0 {
+			writeErrorResponse(writer, http.StatusBadRequest, errors.New("invalid default ttl"))
+			return
+		}
+		nsOptions.DefaultTTL = defaultTTL
+	}
+
+	_, err := hs.cache.CreateNamespace(params.ByName("ns"), nsOptions)
+	if err == caches.NamespaceExistsErr {
+		writeErrorResponse(writer, http.StatusConflict, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusBadRequest, err)
+		return
+	}
+	writer.WriteHeader(http.StatusCreated)
+}
+
+// dropNamespaceHandler 删除当前节点的命名空间和它的所有数据，命名空间不存在的话返回 404 错误码。
+func (hs *HTTPServer) dropNamespaceHandler(writer http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	err := hs.cache.DropNamespace(params.ByName("ns"))
+	if err == caches.NamespaceNotFoundErr {
+		writeErrorResponse(writer, http.StatusNotFound, err)
+		return
+	}
+
+	if err != nil {
+		writeErrorResponse(writer, http.StatusInternalServerError, err)
+	}
+}
+
+// writeErrorResponse 返回 statusCode 状态码和错误信息，错误信息会加上一个 "Error: " 的前缀，方便识别为错误码。
+func writeErrorResponse(writer http.ResponseWriter, statusCode int, err error) {
+	writer.WriteHeader(statusCode)
+	writer.Write([]byte("Error: " + err.Error()))
+}
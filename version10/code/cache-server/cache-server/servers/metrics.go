@@ -0,0 +1,191 @@
+package servers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cache-server/caches"
+)
+
+const (
+	// metricsContentType 是 Prometheus 文本格式的内容类型。
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+)
+
+// latencyBuckets 是请求耗时直方图的桶边界，单位是秒。
+// 缓存的请求一般都在毫秒以内，所以桶主要分布在微秒和毫秒的级别。
+var latencyBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// histogram 是一个简单的直方图，所有的数据都使用 atomic 包进行原子更新。
+type histogram struct {
+
+	// count 是观测的总次数。
+	count uint64
+
+	// sum 是所有观测值的总和，单位是纳秒。
+	sum uint64
+
+	// buckets 记录着落在每个桶里面的观测次数，注意这里不是累计的次数。
+	buckets []uint64
+}
+
+// newHistogram 返回一个新的直方图。
+func newHistogram() *histogram {
+	return &histogram{
+		buckets: make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// observe 记录一次耗时。
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			atomic.AddUint64(&h.buckets[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.sum, uint64(duration))
+	atomic.AddUint64(&h.count, 1)
+}
+
+// metrics 记录着服务器的监控数据，并可以输出成 Prometheus 的文本格式。
+type metrics struct {
+
+	// serverType 是服务器的类型，会作为标签输出。
+	serverType string
+
+	// histograms 记录着每个命令的请求耗时直方图。
+	histograms map[string]*histogram
+
+	// lock 用于保证 histograms 的并发安全。
+	lock *sync.RWMutex
+}
+
+// newMetrics 返回一个新的监控数据实例。
+func newMetrics(serverType string) *metrics {
+	return &metrics{
+		serverType: serverType,
+		histograms: map[string]*histogram{},
+		lock:       &sync.RWMutex{},
+	}
+}
+
+// histogramOf 返回 command 对应的直方图，如果不存在就创建一个。
+func (m *metrics) histogramOf(command string) *histogram {
+	m.lock.RLock()
+	h, ok := m.histograms[command]
+	m.lock.RUnlock()
+	if ok {
+		return h
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if h, ok = m.histograms[command]; !ok {
+		h = newHistogram()
+		m.histograms[command] = h
+	}
+	return h
+}
+
+// observe 记录 command 从 beginTime 开始到现在的耗时。
+func (m *metrics) observe(command string, beginTime time.Time) {
+	m.histogramOf(command).observe(time.Since(beginTime))
+}
+
+// handler 返回输出监控数据的 http 处理器。
+func (m *metrics) handler(cache *caches.Cache, n *node) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", metricsContentType)
+		m.writeTo(writer, cache, n)
+	})
+}
+
+// writeTo 把缓存、请求和集群的监控数据以 Prometheus 的文本格式写入到 w 中。
+func (m *metrics) writeTo(w io.Writer, cache *caches.Cache, n *node) error {
+
+	writer := bufio.NewWriter(w)
+	status := cache.Status()
+
+	// 缓存容量相关的数据
+	writeMetric(writer, "kafo_cache_entries", "gauge", "The number of entries in cache.", float64(status.Count))
+	writeMetric(writer, "kafo_cache_key_bytes", "gauge", "The size of keys in cache.", float64(status.KeySize))
+	writeMetric(writer, "kafo_cache_value_bytes", "gauge", "The size of values in cache.", float64(status.ValueSize))
+	writeMetric(writer, "kafo_cache_memory_bytes", "gauge", "The estimated memory size of entries including overhead.", float64(status.MemorySize))
+
+	// 缓存操作相关的数据
+	writeHeader(writer, "kafo_cache_operations_total", "counter", "The number of cache operations.")
+	writeSample(writer, "kafo_cache_operations_total", `operation="get"`, float64(status.Gets))
+	writeSample(writer, "kafo_cache_operations_total", `operation="set"`, float64(status.Sets))
+	writeSample(writer, "kafo_cache_operations_total", `operation="delete"`, float64(status.Deletes))
+	writeMetric(writer, "kafo_cache_hits_total", "counter", "The number of gets which found the key.", float64(status.Hits))
+	writeMetric(writer, "kafo_cache_misses_total", "counter", "The number of gets which didn't find the key.", float64(status.Misses))
+	writeMetric(writer, "kafo_cache_rejected_total", "counter", "The number of sets rejected because cache is full.", float64(status.Rejected))
+	writeMetric(writer, "kafo_cache_evicted_total", "counter", "The number of entries evicted by eviction policy.", float64(status.Evicted))
+	writeHeader(writer, "kafo_cache_expired_total", "counter", "The number of expired entries removed from cache.")
+	writeSample(writer, "kafo_cache_expired_total", `reason="lazy"`, float64(status.LazyExpired))
+	writeSample(writer, "kafo_cache_expired_total", `reason="gc"`, float64(status.GcExpired))
+
+	// 后台任务相关的数据
+	writeHeader(writer, "kafo_cache_gc_duration_seconds", "summary", "The duration of gc tasks.")
+	writeSample(writer, "kafo_cache_gc_duration_seconds_sum", "", time.Duration(status.GcTime).Seconds())
+	writeSample(writer, "kafo_cache_gc_duration_seconds_count", "", float64(status.GcRuns))
+	writeHeader(writer, "kafo_cache_dump_duration_seconds", "summary", "The duration of dump tasks.")
+	writeSample(writer, "kafo_cache_dump_duration_seconds_sum", "", time.Duration(status.DumpTime).Seconds())
+	writeSample(writer, "kafo_cache_dump_duration_seconds_count", "", float64(status.DumpRuns))
+
+	// 请求耗时相关的数据，为了输出稳定，这里按照命令的名字排序
+	m.lock.RLock()
+	commands := make([]string, 0, len(m.histograms))
+	for command := range m.histograms {
+		commands = append(commands, command)
+	}
+	m.lock.RUnlock()
+	sort.Strings(commands)
+
+	writeHeader(writer, "kafo_request_duration_seconds", "histogram", "The duration of requests handled by server.")
+	for _, command := range commands {
+		h := m.histogramOf(command)
+		labels := fmt.Sprintf(`server=%q,command=%q`, m.serverType, command)
+		cumulative := uint64(0)
+		for i, bound := range latencyBuckets {
+			cumulative += atomic.LoadUint64(&h.buckets[i])
+			writeSample(writer, "kafo_request_duration_seconds_bucket", labels+`,le="`+strconv.FormatFloat(bound, 'g', -1, 64)+`"`, float64(cumulative))
+		}
+		count := atomic.LoadUint64(&h.count)
+		writeSample(writer, "kafo_request_duration_seconds_bucket", labels+`,le="+Inf"`, float64(count))
+		writeSample(writer, "kafo_request_duration_seconds_sum", labels, time.Duration(atomic.LoadUint64(&h.sum)).Seconds())
+		writeSample(writer, "kafo_request_duration_seconds_count", labels, float64(count))
+	}
+
+	// 集群相关的数据
+	writeMetric(writer, "kafo_cluster_nodes", "gauge", "The number of nodes in cluster.", float64(len(n.nodes())))
+	return writer.Flush()
+}
+
+// writeHeader 写入一个指标的帮助信息和类型。
+func writeHeader(writer *bufio.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeSample 写入一个指标的样本，labels 为空的时候不输出标签。
+func writeSample(writer *bufio.Writer, name string, labels string, value float64) {
+	if labels != "" {
+		name = name + "{" + labels + "}"
+	}
+	fmt.Fprintf(writer, "%s %s\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// writeMetric 写入一个没有标签的指标，包括帮助信息、类型和样本。
+func writeMetric(writer *bufio.Writer, name string, metricType string, help string, value float64) {
+	writeHeader(writer, name, metricType, help)
+	writeSample(writer, name, "", value)
+}
@@ -1,6 +1,8 @@
 package servers
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"time"
 
@@ -9,6 +11,11 @@ import (
 	"stathat.com/c/consistent"
 )
 
+const (
+	// defaultLeaveTimeout 是离开集群时默认的等待时间。
+	defaultLeaveTimeout = 5 * time.Second
+)
+
 // node 代表集群中的一个节点，会保存一些和集群相关的数据。
 type node struct {
 
@@ -23,6 +30,9 @@ type node struct {
 
 	// nodeManager 是节点管理器，用于管理节点。
 	nodeManager *memberlist.Memberlist
+
+	// closeCh 用于通知更新一致性哈希的定时任务退出。
+	closeCh chan struct{}
 }
 
 // newNode 创建一个节点实例，并使用 options 去初始化。
@@ -45,6 +55,7 @@ func newNode(options *Options) (*node, error) {
 		address:     helpers.JoinAddressAndPort(options.Address, options.Port),
 		circle:      consistent.New(),
 		nodeManager: nodeManager,
+		closeCh:     make(chan struct{}),
 	}
     
     // 注意这里设置了一致性哈希的虚拟节点数，并开启了自动更新一致性哈希内的物理节点信息
@@ -93,22 +104,55 @@ func (n *node) isCurrentNode(address string) bool {
 	return n.address == address
 }
 
+// checkNode 判断 key 所属的物理节点是否是当前节点，如果不是，就返回重定向错误，并告知正确的节点地址。
+func (n *node) checkNode(key string) error {
+	node, err := n.selectNode(key)
+	if err != nil {
+		return err
+	}
+
+	if !n.isCurrentNode(node) {
+		return fmt.Errorf("redirect to node %s", node)
+	}
+	return nil
+}
+
 // updateCircle 更新一致性哈希的信息。
 // 一致性哈希的信息来源就是 memberlist 实例。
 func (n *node) updateCircle() {
 	n.circle.Set(n.nodes())
 }
 
-// autoUpdateCircle 开启一个定时任务去定期更新一致性哈希的信息。
+// autoUpdateCircle 开启一个定时任务去定期更新一致性哈希的信息，节点离开集群之后这个任务就会退出。
 func (n *node) autoUpdateCircle() {
 	n.updateCircle()
     go func() {
 		ticker := time.NewTicker(time.Duration(n.options.UpdateCircleDuration) * time.Second)
+		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				n.updateCircle()
+			case <-n.closeCh:
+				return
 			}
 		}
 	}()
 }
+
+// leave 让当前节点离开集群，其他节点会马上把这个节点从一致性哈希中移除，而不是等到探测超时。
+// 离开集群的广播最多等待到 ctx 的期限，ctx 没有期限的话就等待 defaultLeaveTimeout。
+func (n *node) leave(ctx context.Context) error {
+	close(n.closeCh)
+
+	timeout := defaultLeaveTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+
+	err := n.nodeManager.Leave(timeout)
+	if shutdownErr := n.nodeManager.Shutdown(); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
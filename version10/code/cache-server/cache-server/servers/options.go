@@ -21,6 +21,10 @@ type Options struct {
 
 	// cluster 是指需要加入的集群，只需要集群中一个节点的地址即可。
 	Cluster []string
+
+	// MetricsAddress 是 TCP 服务器暴露 Prometheus 监控数据的 http 地址，比如 127.0.0.1:5838。
+	// 为空表示不暴露，http 服务器直接使用 /metrics 路由，不需要这个配置。
+	MetricsAddress string
 }
 
 // DefaultOptions 返回一个默认的选项设置。
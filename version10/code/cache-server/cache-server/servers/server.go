@@ -1,12 +1,27 @@
 package servers
 
-import "cache-server/caches"
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cache-server/caches"
+)
 
 const (
 	// APIVersion 代表当前服务的版本。
     // 因为我们做的服务是提供给外部调用的，而版本的升级可能会带来 API 的改动。
     // 我们需要标记当前服务能提供 API 的版本，这样即使后面升级了 API 也不用担心，只要用户调用的版本是正确的，调用就不会出错
 	APIVersion = "v1"
+
+	// absoluteMode 是绝对过期方式的名字，ttl 从创建开始计算，这也是默认的过期方式。
+	absoluteMode = "absolute"
+
+	// slidingMode 是空闲过期方式的名字，ttl 从最后一次访问开始计算。
+	slidingMode = "sliding"
+
+	// defaultScanCount 是遍历 key 的时候默认每次返回的数量，只是一个参考值。
+	defaultScanCount = 100
 )
 
 // Server 是服务器的抽象接口。
@@ -14,6 +29,10 @@ type Server interface {
 
 	// Run 方法会启动这个服务器。
 	Run() error
+
+	// Close 方法会优雅地关闭这个服务器。
+	// 关闭的时候会先停止接收新的请求，然后等待正在处理的请求处理完，最后离开集群，最多等待到 ctx 的期限。
+	Close(ctx context.Context) error
 }
 
 // NewServer 通过一个 cache 实例和 options 实例来创建并初始化一个服务器实例。
@@ -23,3 +42,24 @@ func NewServer(cache *caches.Cache, options Options) (Server, error) {
 	}
 	return NewHTTPServer(cache, &options)
 }
+
+// setOptionsOf 根据过期方式的名字、最长寿命和标签返回添加数据的配置，mode 为空表示默认的绝对过期。
+func setOptionsOf(mode string, maxAge time.Duration, tags []string) ([]caches.SetOption, error) {
+	var opts []caches.SetOption
+	switch mode {
+	case "", absoluteMode:
+	case slidingMode:
+		opts = append(opts, caches.Sliding())
+	default:
+		return nil, errors.New("unknown ttl mode " + mode)
+	}
+
+	if maxAge > 0 {
+		opts = append(opts, caches.WithMaxAge(maxAge))
+	}
+
+	if len(tags) > 0 {
+		opts = append(opts, caches.WithTags(tags...))
+	}
+	return opts, nil
+}
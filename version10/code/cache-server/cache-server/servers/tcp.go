@@ -1,10 +1,15 @@
 package servers
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net/http"
+	"sync/atomic"
+	"time"
 
 	"cache-server/caches"
 	"cache-server/helpers"
@@ -26,19 +31,182 @@ const (
 
 	// nodesCommand 是 nodes 命令。
 	nodesCommand = byte(5)
+
+	// dumpCommand 是 dump 命令，用于马上持久化当前节点的缓存数据。
+	dumpCommand = byte(6)
+
+	// restoreCommand 是 restore 命令，用于使用持久化文件替换掉当前节点的缓存数据。
+	restoreCommand = byte(7)
+
+	// flushCommand 是 flush 命令，用于清空当前节点的缓存数据。
+	flushCommand = byte(8)
+
+	// setAtCommand 是 setAt 命令，和 set 命令一样添加数据，只是使用过期的时间点代替 ttl。
+	setAtCommand = byte(9)
+
+	// ttlCommand 是 ttl 命令，用于查看数据还剩多长时间过期。
+	ttlCommand = byte(10)
+
+	// expireCommand 是 expire 命令，用于重新设置数据的有效期。
+	expireCommand = byte(11)
+
+	// persistCommand 是 persist 命令，用于移除数据的所有过期设置。
+	persistCommand = byte(12)
+
+	// touchCommand 是 touch 命令，用于更新数据的访问时间。
+	touchCommand = byte(13)
+
+	// incrByCommand 是 incrBy 命令，用于原子地加减整数。
+	incrByCommand = byte(14)
+
+	// getWithVersionCommand 是 getWithVersion 命令，用于获取数据和它的版本号。
+	getWithVersionCommand = byte(15)
+
+	// compareAndSetCommand 是 compareAndSet 命令，只有数据的版本号和期望的一致才添加数据。
+	compareAndSetCommand = byte(16)
+
+	// setIfAbsentCommand 是 setIfAbsent 命令，只有数据不存在才添加数据。
+	setIfAbsentCommand = byte(17)
+
+	// setIfPresentCommand 是 setIfPresent 命令，只有数据存在才添加数据。
+	setIfPresentCommand = byte(18)
+
+	// mgetCommand 是 mget 命令，用于批量获取数据。
+	mgetCommand = byte(19)
+
+	// msetCommand 是 mset 命令，用于批量添加数据。
+	msetCommand = byte(20)
+
+	// mdeleteCommand 是 mdelete 命令，用于批量删除数据。
+	mdeleteCommand = byte(21)
+
+	// scanCommand 是 scan 命令，用于遍历当前节点的 key。
+	scanCommand = byte(22)
+
+	// deleteByPrefixCommand 是 deleteByPrefix 命令，用于删除当前节点所有以某个前缀开头的数据。
+	deleteByPrefixCommand = byte(23)
+
+	// deleteByTagCommand 是 deleteByTag 命令，用于删除当前节点所有带有某个标签的数据。
+	deleteByTagCommand = byte(24)
+
+	// namespaceCommand 是 namespace 命令，用于在指定的命名空间中执行其他操作数据的命令。
+	namespaceCommand = byte(25)
+
+	// createNamespaceCommand 是 createNamespace 命令，用于在当前节点创建命名空间。
+	createNamespaceCommand = byte(26)
+
+	// dropNamespaceCommand 是 dropNamespace 命令，用于删除当前节点的命名空间和它的所有数据。
+	dropNamespaceCommand = byte(27)
+
+	// hsetCommand 是 hset 命令，用于设置哈希中的字段。
+	hsetCommand = byte(28)
+
+	// hgetCommand 是 hget 命令，用于获取哈希中的一个字段。
+	hgetCommand = byte(29)
+
+	// hdelCommand 是 hdel 命令，用于删除哈希中的字段。
+	hdelCommand = byte(30)
+
+	// hgetAllCommand 是 hgetAll 命令，用于获取哈希中的所有字段。
+	hgetAllCommand = byte(31)
+
+	// lpushCommand 是 lpush 命令，用于在列表头部插入元素。
+	lpushCommand = byte(32)
+
+	// rpushCommand 是 rpush 命令，用于在列表尾部追加元素。
+	rpushCommand = byte(33)
+
+	// lpopCommand 是 lpop 命令，用于弹出列表头部的元素。
+	lpopCommand = byte(34)
+
+	// rpopCommand 是 rpop 命令，用于弹出列表尾部的元素。
+	rpopCommand = byte(35)
+
+	// lrangeCommand 是 lrange 命令，用于获取列表中一个范围的元素。
+	lrangeCommand = byte(36)
+
+	// ltrimCommand 是 ltrim 命令，用于只保留列表中一个范围的元素。
+	ltrimCommand = byte(37)
+
+	// llenCommand 是 llen 命令，用于获取列表的长度。
+	llenCommand = byte(38)
+
+	// blpopCommand 是 blpop 命令，列表为空的时候会阻塞这个连接，直到有新的元素或者超时。
+	blpopCommand = byte(39)
+
+	// brpopCommand 是 brpop 命令，和 blpop 命令一样，只是从尾部弹出。
+	brpopCommand = byte(40)
+
+	// saddCommand 是 sadd 命令，用于往集合中添加成员。
+	saddCommand = byte(41)
+
+	// sremCommand 是 srem 命令，用于从集合中移除成员。
+	sremCommand = byte(42)
+
+	// sismemberCommand 是 sismember 命令，用于判断一个成员是否在集合中。
+	sismemberCommand = byte(43)
+
+	// smembersCommand 是 smembers 命令，用于获取集合中所有的成员。
+	smembersCommand = byte(44)
+
+	// scardCommand 是 scard 命令，用于获取集合的成员个数。
+	scardCommand = byte(45)
+
+	// zaddCommand 是 zadd 命令，用于往有序集合中添加成员或者更新成员的分数。
+	zaddCommand = byte(46)
+
+	// zincrByCommand 是 zincrBy 命令，用于原子地加减有序集合中成员的分数。
+	zincrByCommand = byte(47)
+
+	// zrangeCommand 是 zrange 命令，用于按照排名获取有序集合中一个范围的成员。
+	zrangeCommand = byte(48)
+
+	// zrangeByScoreCommand 是 zrangeByScore 命令，用于按照分数获取有序集合中一个范围的成员。
+	zrangeByScoreCommand = byte(49)
+
+	// zrankCommand 是 zrank 命令，用于获取有序集合中成员的排名。
+	zrankCommand = byte(50)
+
+	// zscoreCommand 是 zscore 命令，用于获取有序集合中成员的分数。
+	zscoreCommand = byte(51)
+
+	// zremCommand 是 zrem 命令，用于从有序集合中移除成员。
+	zremCommand = byte(52)
+
+	// zcardCommand 是 zcard 命令，用于获取有序集合的成员个数。
+	zcardCommand = byte(53)
 )
 
 var (
+	// serverClosingErr 是服务器正在关闭的错误，客户端收到这个错误之后应该换一个节点重试。
+	serverClosingErr = errors.New("server is closing")
+
 	// commandNeedsMoreArgumentsErr 是命令需要更多参数的错误。
 	commandNeedsMoreArgumentsErr = errors.New("command needs more arguments")
 
 	// notFoundErr 是找不到的错误。
 	notFoundErr = errors.New("not found")
+
+	// invalidArgumentErr 是定长参数的长度不对时返回的错误，比如 ttl 不是 8 个字节。
+	invalidArgumentErr = errors.New("argument should be 8 bytes in big endian")
+
+	// commandNotSupportedErr 是命令不能在命名空间中执行的错误。
+	commandNotSupportedErr = errors.New("command is not supported in namespace")
 )
 
+// cacheHandler 是操作缓存数据的命令的处理器，cache 是命令所在的命名空间。
+type cacheHandler func(cache *caches.Cache, args [][]byte) ([]byte, error)
+
 // TCPServer 是 TCP 类型的服务器。
 type TCPServer struct {
 
+	// requests 记录着正在处理的请求数量，关闭服务器的时候需要等待这些请求处理完。
+	// 因为使用了 atomic 包进行原子更新，所以放在结构体的最前面，保证在 32 位平台上也是 64 位对齐的。
+	requests int64
+
+	// closing 表示服务器是否正在关闭，正在关闭的服务器不会再处理新的请求。
+	closing int32
+
 	// node 是内部用于记录集群信息的实例。
     // 这里使用了 Go 语言的组合模式，这样当前服务器实例也可以说成是节点实例，方法都可以互通。
 	*node
@@ -46,11 +214,26 @@ type TCPServer struct {
 	// cache 是内部用于存储数据的缓存组件。
 	cache *caches.Cache
 
+	// cacheHandlers 存储着所有操作缓存数据的命令的处理器，这些命令都可以通过 namespace 命令在命名空间中执行。
+	cacheHandlers map[byte]cacheHandler
+
 	// server 是内部真正用于服务的服务器。
 	server *vex.Server
 
 	// options 存储着这个服务器的选项配置。
 	options *Options
+
+	// metrics 记录着这个服务器的监控数据。
+	metrics *metrics
+
+	// metricsServer 是暴露监控数据的 http 服务器，没有配置监控地址的时候为空。
+	metricsServer *http.Server
+
+	// ctx 会在服务器关闭的时候取消，用于让阻塞的命令马上返回。
+	ctx context.Context
+
+	// cancel 用于取消 ctx。
+	cancel context.CancelFunc
 }
 
 // NewTCPServer 返回新的 TCP 服务器。
@@ -61,36 +244,167 @@ func NewTCPServer(cache *caches.Cache, options *Options) (*TCPServer, error) {
 		return nil, err
 	}
 
-	return &TCPServer{
+	ts := &TCPServer{
 		node: n,
 		cache:   cache,
+		cacheHandlers: map[byte]cacheHandler{},
 		server:  vex.NewServer(),
 		options: options,
-	}, nil
+		metrics: newMetrics("tcp"),
+	}
+	ts.ctx, ts.cancel = context.WithCancel(context.Background())
+
+	// TCP 服务器没办法直接给 Prometheus 抓取，所以配置了监控地址的话，需要额外开启一个 http 服务
+	if options.MetricsAddress != "" {
+		ts.metricsServer = &http.Server{
+			Addr:    options.MetricsAddress,
+			Handler: ts.metrics.handler(cache, n),
+		}
+	}
+	return ts, nil
 }
 
 // Run 运行这个 TCP 服务器。
 func (ts *TCPServer) Run() error {
     // 注册几种命令的处理器
-	ts.server.RegisterHandler(getCommand, ts.getHandler)
-	ts.server.RegisterHandler(setCommand, ts.setHandler)
-	ts.server.RegisterHandler(deleteCommand, ts.deleteHandler)
-	ts.server.RegisterHandler(statusCommand, ts.statusHandler)
+	ts.registerCacheHandler(getCommand, "get", ts.getHandler)
+	ts.registerCacheHandler(setCommand, "set", ts.setHandler)
+	ts.registerCacheHandler(setAtCommand, "setAt", ts.setAtHandler)
+	ts.registerCacheHandler(deleteCommand, "delete", ts.deleteHandler)
+	ts.registerCacheHandler(statusCommand, "status", ts.statusHandler)
     
     // 新增的 nodes 命令，用于获取集群所有节点的名称。
-	ts.server.RegisterHandler(nodesCommand, ts.nodesHandler)
+	ts.server.RegisterHandler(nodesCommand, ts.withMetrics("nodes", ts.nodesHandler))
+
+	// 查看和修改数据有效期的命令
+	ts.registerCacheHandler(ttlCommand, "ttl", ts.ttlHandler)
+	ts.registerCacheHandler(expireCommand, "expire", ts.expireHandler)
+	ts.registerCacheHandler(persistCommand, "persist", ts.persistHandler)
+	ts.registerCacheHandler(touchCommand, "touch", ts.touchHandler)
+
+	// 原子加减整数的命令
+	ts.registerCacheHandler(incrByCommand, "incrBy", ts.incrByHandler)
+
+	// 带版本号和条件的读写命令
+	ts.registerCacheHandler(getWithVersionCommand, "getWithVersion", ts.getWithVersionHandler)
+	ts.registerCacheHandler(compareAndSetCommand, "compareAndSet", ts.compareAndSetHandler)
+	ts.registerCacheHandler(setIfAbsentCommand, "setIfAbsent", ts.setIfAbsentHandler)
+	ts.registerCacheHandler(setIfPresentCommand, "setIfPresent", ts.setIfPresentHandler)
+
+	// 批量操作的命令，不属于当前节点的 key 会单独返回重定向错误
+	ts.registerCacheHandler(mgetCommand, "mget", ts.mgetHandler)
+	ts.registerCacheHandler(msetCommand, "mset", ts.msetHandler)
+	ts.registerCacheHandler(mdeleteCommand, "mdelete", ts.mdeleteHandler)
+
+	// 遍历 key 的命令，只会遍历当前节点
+	ts.registerCacheHandler(scanCommand, "scan", ts.scanHandler)
+
+	// 按照前缀和标签批量删除的命令，只会删除当前节点的数据
+	ts.registerCacheHandler(deleteByPrefixCommand, "deleteByPrefix", ts.deleteByPrefixHandler)
+	ts.registerCacheHandler(deleteByTagCommand, "deleteByTag", ts.deleteByTagHandler)
+
+	// 哈希类型的命令
+	ts.registerCacheHandler(hsetCommand, "hset", ts.hsetHandler)
+	ts.registerCacheHandler(hgetCommand, "hget", ts.hgetHandler)
+	ts.registerCacheHandler(hdelCommand, "hdel", ts.hdelHandler)
+	ts.registerCacheHandler(hgetAllCommand, "hgetAll", ts.hgetAllHandler)
+
+	// 列表类型的命令，阻塞的弹出只会阻塞当前连接，客户端需要使用单独的连接
+	ts.registerCacheHandler(lpushCommand, "lpush", ts.lpushHandler)
+	ts.registerCacheHandler(rpushCommand, "rpush", ts.rpushHandler)
+	ts.registerCacheHandler(lpopCommand, "lpop", ts.lpopHandler)
+	ts.registerCacheHandler(rpopCommand, "rpop", ts.rpopHandler)
+	ts.registerCacheHandler(lrangeCommand, "lrange", ts.lrangeHandler)
+	ts.registerCacheHandler(ltrimCommand, "ltrim", ts.ltrimHandler)
+	ts.registerCacheHandler(llenCommand, "llen", ts.llenHandler)
+	ts.registerCacheHandler(blpopCommand, "blpop", ts.blpopHandler)
+	ts.registerCacheHandler(brpopCommand, "brpop", ts.brpopHandler)
+
+	// 集合和有序集合类型的命令
+	ts.registerCacheHandler(saddCommand, "sadd", ts.saddHandler)
+	ts.registerCacheHandler(sremCommand, "srem", ts.sremHandler)
+	ts.registerCacheHandler(sismemberCommand, "sismember", ts.sismemberHandler)
+	ts.registerCacheHandler(smembersCommand, "smembers", ts.smembersHandler)
+	ts.registerCacheHandler(scardCommand, "scard", ts.scardHandler)
+	ts.registerCacheHandler(zaddCommand, "zadd", ts.zaddHandler)
+	ts.registerCacheHandler(zincrByCommand, "zincrBy", ts.zincrByHandler)
+	ts.registerCacheHandler(zrangeCommand, "zrange", ts.zrangeHandler)
+	ts.registerCacheHandler(zrangeByScoreCommand, "zrangeByScore", ts.zrangeByScoreHandler)
+	ts.registerCacheHandler(zrankCommand, "zrank", ts.zrankHandler)
+	ts.registerCacheHandler(zscoreCommand, "zscore", ts.zscoreHandler)
+	ts.registerCacheHandler(zremCommand, "zrem", ts.zremHandler)
+	ts.registerCacheHandler(zcardCommand, "zcard", ts.zcardHandler)
+
+	// 管理相关的命令，只会作用于当前节点
+	ts.server.RegisterHandler(dumpCommand, ts.withMetrics("dump", ts.dumpHandler))
+	ts.server.RegisterHandler(restoreCommand, ts.withMetrics("restore", ts.restoreHandler))
+	ts.registerCacheHandler(flushCommand, "flush", ts.flushHandler)
+
+	// 命名空间相关的命令，命名空间需要在每个节点上分别创建和删除
+	ts.server.RegisterHandler(namespaceCommand, ts.withMetrics("namespace", ts.namespaceHandler))
+	ts.server.RegisterHandler(createNamespaceCommand, ts.withMetrics("createNamespace", ts.createNamespaceHandler))
+	ts.server.RegisterHandler(dropNamespaceCommand, ts.withMetrics("dropNamespace", ts.dropNamespaceHandler))
+
+	// 配置了监控地址的话，需要额外开启一个 http 服务
+	if ts.metricsServer != nil {
+		go ts.metricsServer.ListenAndServe()
+	}
 	return ts.server.ListenAndServe("tcp", helpers.JoinAddressAndPort(ts.options.Address, ts.options.Port))
 }
 
-// Close 用于关闭服务器。
-func (ts *TCPServer) Close() error {
-	return ts.server.Close()
+// registerCacheHandler 注册操作缓存数据的命令，直接执行的时候使用默认命名空间，也可以通过 namespace 命令在其他命名空间中执行。
+func (ts *TCPServer) registerCacheHandler(command byte, name string, handler cacheHandler) {
+	ts.cacheHandlers[command] = handler
+	ts.server.RegisterHandler(command, ts.withMetrics(name, func(args [][]byte) ([]byte, error) {
+		return handler(ts.cache, args)
+	}))
+}
+
+// withMetrics 包装 handler，记录每次请求的耗时，同时记录正在处理的请求数量，用于关闭服务器时等待请求处理完。
+func (ts *TCPServer) withMetrics(command string, handler func(args [][]byte) ([]byte, error)) func(args [][]byte) ([]byte, error) {
+	return func(args [][]byte) ([]byte, error) {
+		atomic.AddInt64(&ts.requests, 1)
+		defer atomic.AddInt64(&ts.requests, -1)
+		if atomic.LoadInt32(&ts.closing) == 1 {
+			return nil, serverClosingErr
+		}
+
+		defer ts.metrics.observe(command, time.Now())
+		return handler(args)
+	}
+}
+
+// Close 优雅地关闭这个服务器。
+// 先停止接收新的连接，之后已有连接上的新请求都会返回 serverClosingErr，等正在处理的请求处理完之后再离开集群。
+func (ts *TCPServer) Close(ctx context.Context) error {
+	atomic.StoreInt32(&ts.closing, 1)
+	ts.cancel()
+	err := ts.server.Close()
+	if ts.metricsServer != nil {
+		ts.metricsServer.Close()
+	}
+
+	// vex 没有提供等待请求处理完的方法，所以这里定期检查正在处理的请求数量
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&ts.requests) > 0 && err == nil {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if leaveErr := ts.leave(ctx); err == nil {
+		err = leaveErr
+	}
+	return err
 }
 
 // =======================================================================
 
 // getHandler 是处理 get 命令的的处理器。
-func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
+func (ts *TCPServer) getHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
     
     // 检查参数个数是否足够
 	if len(args) < 1 {
@@ -110,15 +424,16 @@ func (ts *TCPServer) getHandler(args [][]byte) (body []byte, err error) {
 	}
 
     // 调用缓存的 Get 方法，如果不存在就返回 notFoundErr 错误
-	value, ok := ts.cache.Get(key)
+	value, ok := cache.Get(key)
 	if !ok {
 		return value, notFoundErr
 	}
 	return value, nil
 }
 
-// setHandler 是处理 set 命令的处理器。
-func (ts *TCPServer) setHandler(args [][]byte) (body []byte, err error) {
+// setHandler 是处理 set 命令的处理器，参数是 8 个字节大端存储的 ttl、key、value、可选的 ttl 单位、过期方式、最长寿命和标签列表。
+// 和其他 TCP 命令一样，ttl 和最长寿命的单位默认是纳秒，也可以使用第四个参数指定其他单位，ttl 的 8 个字节全是 1 表示 caches.Forever。
+func (ts *TCPServer) setHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
     
     // 检查参数个数是否足够
 	if len(args) < 3 {
@@ -138,16 +453,97 @@ func (ts *TCPServer) setHandler(args [][]byte) (body []byte, err error) {
 	}
 
     // 读取 ttl，注意这里使用大端的方式读取，所以要求客户端也以大端的方式进行存储
-	ttl := int64(binary.BigEndian.Uint64(args[0]))
-	err = ts.cache.SetWithTTL(key, args[2], ttl)
+    // 第四个参数是可选的 ttl 单位，比如 ns、ms 和 s，没有传的话和其他 TCP 命令一样按照纳秒处理
+	unit := time.Nanosecond
+	if len(args) > 3 {
+		unit, err = ttlUnitOf(args[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+    // 第五个参数是可选的过期方式，可以是 absolute 或者 sliding，第六个参数是可选的最长寿命，单位和 ttl 一样
+	mode := ""
+	if len(args) > 4 {
+		mode = string(args[4])
+	}
+
+	maxAge := time.Duration(0)
+	if len(args) > 5 {
+		n, err := uint64OfArg(args[5])
+		if err != nil {
+			return nil, err
+		}
+		maxAge = time.Duration(n) * unit
+	}
+
+    // 第七个参数是可选的标签列表，使用 encodeBatchKeys 编码
+	tags, err := tagsOfArgs(args, 6)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOf(mode, maxAge, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// 全是 1 的 ttl 就是 caches.Forever 的补码，不能再乘上单位
+	duration := time.Duration(ttl) * unit
+	if ttl == math.MaxUint64 {
+		duration = caches.Forever
+	}
+
+	err = cache.SetWithTTL(key, args[2], duration, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return nil, nil
 }
 
+// ttlUnitOf 解析 set 命令中 ttl 的单位，支持的单位和 time.ParseDuration 一样。
+func ttlUnitOf(unit []byte) (time.Duration, error) {
+	duration, err := time.ParseDuration("1" + string(unit))
+	if err != nil || duration <= 0 {
+		return 0, errors.New("unknown ttl unit " + string(unit))
+	}
+	return duration, nil
+}
+
+// setAtHandler 是处理 setAt 命令的处理器，参数是过期时间点、key 和 value，过期时间点是 8 个字节大端存储的 Unix 时间戳，单位是纳秒。
+func (ts *TCPServer) setAtHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+
+	// 检查参数个数是否足够
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	// 使用一致性哈希选择出这个 key 所属的物理节点
+	key := string(args[1])
+	node, err := ts.selectNode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 判断这个 key 所属的物理节点是否是当前节点，如果不是，需要响应重定向信息给客户端，并告知正确的节点地址
+	if !ts.isCurrentNode(node) {
+		return nil, fmt.Errorf("redirect to node %s", node)
+	}
+
+	expireAt, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return nil, cache.SetWithExpireAt(key, args[2], time.Unix(0, int64(expireAt)))
+}
+
 // deleteHandler 是处理 delete 命令的处理器。
-func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
+func (ts *TCPServer) deleteHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
     
     // 检查参数个数是否足够
 	if len(args) < 1 {
@@ -167,7 +563,7 @@ func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
 	}
 
     // 删除指定的数据
-	err = ts.cache.Delete(key)
+	err = cache.Delete(key)
 	if err != nil {
 		return nil, err
 	}
@@ -175,11 +571,1001 @@ func (ts *TCPServer) deleteHandler(args [][]byte) (body []byte, err error) {
 }
 
 // statusHandler 是返回缓存状态的处理器。
-func (ts *TCPServer) statusHandler(args [][]byte) (body []byte, err error) {
-	return json.Marshal(ts.cache.Status())
+func (ts *TCPServer) statusHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return json.Marshal(cache.Status())
 }
 
 // nodesHandler 是返回集群所有节点名称的处理器。
 func (ts *TCPServer) nodesHandler(args [][]byte) (body []byte, err error) {
 	return json.Marshal(ts.nodes())
 }
+
+// ttlHandler 是返回数据还剩多长时间过期的处理器，参数是 key，返回 8 个字节大端存储的剩余时间，单位是纳秒，永不过期的数据返回 0。
+func (ts *TCPServer) ttlHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, ok := cache.TTL(key)
+	if !ok {
+		return nil, notFoundErr
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(ttl))
+	return body, nil
+}
+
+// expireHandler 是重新设置数据有效期的处理器，参数是 8 个字节大端存储的 ttl 和 key，ttl 的单位是纳秒。
+func (ts *TCPServer) expireHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[1])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return nil, updateResultOf(cache.Expire(key, time.Duration(ttl)))
+}
+
+// persistHandler 是移除数据所有过期设置的处理器，参数是 key。
+func (ts *TCPServer) persistHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+	return nil, updateResultOf(cache.Persist(key))
+}
+
+// touchHandler 是更新数据访问时间的处理器，参数是 key。
+func (ts *TCPServer) touchHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+	return nil, updateResultOf(cache.Touch(key))
+}
+
+// incrByHandler 是原子加减整数的处理器，参数是 8 个字节大端存储的 delta、key、可选的 ttl、过期方式和最长寿命，返回 8 个字节大端存储的结果。
+// delta 和结果都是补码表示的 int64，ttl 和最长寿命的单位是纳秒，只在 key 不存在需要创建数据的时候使用。
+func (ts *TCPServer) incrByHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[1])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	delta, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := uint64(caches.NeverDie)
+	if len(args) > 2 {
+		if ttl, err = uint64OfArg(args[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	opts, err := setOptionsOfArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := cache.IncrBy(key, int64(delta), time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, uint64(result))
+	return body, nil
+}
+
+// setOptionsOfArgs 从 args[from] 开始解析可选的过期方式、最长寿命和标签列表，最长寿命是 8 个字节大端存储的纳秒数。
+func setOptionsOfArgs(args [][]byte, from int) ([]caches.SetOption, error) {
+	mode := ""
+	if len(args) > from {
+		mode = string(args[from])
+	}
+
+	maxAge := uint64(0)
+	if len(args) > from+1 {
+		var err error
+		if maxAge, err = uint64OfArg(args[from+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	tags, err := tagsOfArgs(args, from+2)
+	if err != nil {
+		return nil, err
+	}
+	return setOptionsOf(mode, time.Duration(maxAge), tags)
+}
+
+// tagsOfArgs 解析 args[index] 中使用 encodeBatchKeys 编码的标签列表，没有这个参数的话返回空。
+func tagsOfArgs(args [][]byte, index int) ([]string, error) {
+	if len(args) <= index {
+		return nil, nil
+	}
+
+	tags, err := decodeBatchKeys(args[index])
+	return stringsOf(tags), err
+}
+
+// getWithVersionHandler 是获取数据和版本号的处理器，参数是 key，返回 8 个字节大端存储的版本号，后面跟着数据。
+func (ts *TCPServer) getWithVersionHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	value, version, ok := cache.GetWithVersion(key)
+	if !ok {
+		return nil, notFoundErr
+	}
+
+	body = make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(body, version)
+	return append(body, value...), nil
+}
+
+// compareAndSetHandler 是 CAS 的处理器，参数是 8 个字节大端存储的期望版本号，后面和 setIfAbsent 命令的参数一样，返回新数据的版本号。
+func (ts *TCPServer) compareAndSetHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 4 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	expectedVersion, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return ts.conditionalSet(args[1:], func(key string, value []byte, ttl time.Duration, opts ...caches.SetOption) (uint64, error) {
+		return cache.CompareAndSet(key, value, expectedVersion, ttl, opts...)
+	})
+}
+
+// setIfAbsentHandler 是只在数据不存在时添加数据的处理器，参数是 8 个字节大端存储的 ttl、key、value、可选的过期方式和最长寿命。
+// ttl 和最长寿命的单位是纳秒，返回 8 个字节大端存储的新数据的版本号。
+func (ts *TCPServer) setIfAbsentHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.conditionalSet(args, cache.SetIfAbsent)
+}
+
+// setIfPresentHandler 是只在数据存在时添加数据的处理器，参数和 setIfAbsent 命令一样。
+func (ts *TCPServer) setIfPresentHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.conditionalSet(args, cache.SetIfPresent)
+}
+
+// conditionalSet 解析条件添加命令的参数，然后使用 set 添加数据，返回 8 个字节大端存储的新数据的版本号。
+func (ts *TCPServer) conditionalSet(args [][]byte, set func(key string, value []byte, ttl time.Duration, opts ...caches.SetOption) (uint64, error)) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[1])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := set(key, args[2], time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	body = make([]byte, 8)
+	binary.BigEndian.PutUint64(body, version)
+	return body, nil
+}
+
+// mgetHandler 是批量获取数据的处理器，参数是所有的 key，返回使用 encodeBatchResults 编码的结果。
+func (ts *TCPServer) mgetHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return encodeBatchResults(batchGet(ts.node, cache, stringsOf(args))), nil
+}
+
+// msetHandler 是批量添加数据的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表，然后是交替出现的 key 和 value。
+// ttl 和最长寿命的单位是纳秒，标签列表使用 encodeBatchKeys 编码，所有数据都使用同样的设置，返回使用 encodeBatchResults 编码的结果。
+func (ts *TCPServer) msetHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 4 || len(args)%2 != 0 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := args[4:]
+	keys := make([]string, 0, len(entries)/2)
+	values := make([][]byte, 0, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		keys = append(keys, string(entries[i]))
+		values = append(values, entries[i+1])
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return encodeBatchResults(batchSet(ts.node, cache, keys, values, time.Duration(ttl), opts)), nil
+}
+
+// mdeleteHandler 是批量删除数据的处理器，参数是所有的 key，返回使用 encodeBatchResults 编码的结果。
+func (ts *TCPServer) mdeleteHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return encodeBatchResults(batchDelete(ts.node, cache, stringsOf(args))), nil
+}
+
+// scanHandler 是遍历当前节点 key 的处理器，参数是 8 个字节大端存储的 cursor、可选的 pattern 和 8 个字节大端存储的 count。
+// 返回 8 个字节大端存储的下一次遍历使用的 cursor，后面是使用 encodeBatchKeys 编码的 key 列表。
+func (ts *TCPServer) scanHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	pattern := ""
+	if len(args) > 1 {
+		pattern = string(args[1])
+	}
+
+	count := uint64(defaultScanCount)
+	if len(args) > 2 {
+		if count, err = uint64OfArg(args[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	cursor, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	keys, cursor, err := cache.Scan(cursor, pattern, int(count))
+	if err != nil {
+		return nil, err
+	}
+	return append(uint64ToBytes(cursor), encodeBatchKeys(keys)...), nil
+}
+
+// deleteByPrefixHandler 是删除当前节点所有以某个前缀开头的数据的处理器，参数是前缀，返回 8 个字节大端存储的删除数量。
+func (ts *TCPServer) deleteByPrefixHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	deleted, err := cache.DeleteByPrefix(string(args[0]))
+	return uint64ToBytes(uint64(deleted)), err
+}
+
+// deleteByTagHandler 是删除当前节点所有带有某个标签的数据的处理器，参数是标签，返回 8 个字节大端存储的删除数量。
+func (ts *TCPServer) deleteByTagHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	deleted, err := cache.DeleteByTag(string(args[0]))
+	return uint64ToBytes(uint64(deleted)), err
+}
+
+// hsetHandler 是设置哈希字段的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表、key，然后是交替出现的字段名和字段值。
+// ttl 和最长寿命的单位是纳秒，这些设置只在创建哈希的时候使用，返回 8 个字节大端存储的新增字段个数。
+func (ts *TCPServer) hsetHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 7 || len(args)%2 == 0 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[4])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := args[5:]
+	fields := make(map[string][]byte, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		fields[string(entries[i])] = entries[i+1]
+	}
+
+	added, err := cache.HSet(key, fields, time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(added)), nil
+}
+
+// hgetHandler 是获取哈希字段的处理器，参数是 key 和字段名，字段不存在的话返回 notFoundErr。
+func (ts *TCPServer) hgetHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	data, ok, err := cache.HGet(key, string(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return data, nil
+}
+
+// hdelHandler 是删除哈希字段的处理器，参数是 key 和所有要删除的字段名，返回 8 个字节大端存储的删除个数。
+func (ts *TCPServer) hdelHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	deleted, err := cache.HDel(key, stringsOf(args[1:])...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(deleted)), nil
+}
+
+// hgetAllHandler 是获取哈希所有字段的处理器，参数是 key，返回使用 encodeBatchValues 编码的交替出现的字段名和字段值。
+// key 不存在的话返回空的列表。
+func (ts *TCPServer) hgetAllHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	hash, err := cache.HGetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([][]byte, 0, 2*len(hash))
+	for field, data := range hash {
+		entries = append(entries, []byte(field), data)
+	}
+	return encodeBatchValues(entries), nil
+}
+
+// lpushHandler 是在列表头部插入元素的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表、key，然后是所有的元素。
+// ttl 和最长寿命的单位是纳秒，这些设置只在创建列表的时候使用，返回 8 个字节大端存储的插入之后列表的长度。
+func (ts *TCPServer) lpushHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.push(args, cache.LPush)
+}
+
+// rpushHandler 是在列表尾部追加元素的处理器，参数和 lpush 命令一样。
+func (ts *TCPServer) rpushHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.push(args, cache.RPush)
+}
+
+// push 解析插入元素的命令的参数，然后使用 push 插入元素，返回 8 个字节大端存储的插入之后列表的长度。
+func (ts *TCPServer) push(args [][]byte, push func(key string, values [][]byte, ttl time.Duration, opts ...caches.SetOption) (int, error)) (body []byte, err error) {
+	if len(args) < 6 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[4])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := push(key, args[5:], time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(length)), nil
+}
+
+// lpopHandler 是弹出列表头部元素的处理器，参数是 key，列表为空的话返回 notFoundErr。
+func (ts *TCPServer) lpopHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.pop(args, cache.LPop)
+}
+
+// rpopHandler 是弹出列表尾部元素的处理器，参数和 lpop 命令一样。
+func (ts *TCPServer) rpopHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.pop(args, cache.RPop)
+}
+
+// pop 解析弹出元素的命令的参数，然后使用 pop 弹出元素，列表为空的话返回 notFoundErr。
+func (ts *TCPServer) pop(args [][]byte, pop func(key string) ([]byte, bool, error)) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	data, ok, err := pop(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, notFoundErr
+	}
+	return data, nil
+}
+
+// blpopHandler 是阻塞地弹出列表头部元素的处理器，参数是 key 和 8 个字节大端存储的超时时间，单位是纳秒，0 表示等待 maxBlockingPopTimeout。
+// 列表为空的时候会阻塞当前连接，直到有新的元素、超时或者服务器关闭，超时的话返回 notFoundErr。
+func (ts *TCPServer) blpopHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.blockingPop(args, cache.BLPop)
+}
+
+// brpopHandler 是阻塞地弹出列表尾部元素的处理器，参数和 blpop 命令一样。
+func (ts *TCPServer) brpopHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.blockingPop(args, cache.BRPop)
+}
+
+// maxBlockingPopTimeout 是服务端阻塞弹出元素的最长等待时间。
+// 处理器拿不到连接，客户端断开之后服务端也不知道，还会继续等待并弹出下一个元素，这个元素写给断开的连接就丢失了。
+// 所以服务端最多等待这么久，超时的客户端需要重新发送命令，这样断开的连接最多在这段时间内占用等待者的位置。
+const maxBlockingPopTimeout = 30 * time.Second
+
+// blockingPop 解析阻塞弹出元素的命令的参数，然后使用 pop 弹出元素，超时的话返回 notFoundErr。
+// 超时时间为 0 或者超过 maxBlockingPopTimeout 的话都只会等待 maxBlockingPopTimeout。
+func (ts *TCPServer) blockingPop(args [][]byte, pop func(ctx context.Context, key string) ([]byte, error)) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	timeout, err := uint64OfArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	wait := time.Duration(timeout)
+	if wait <= 0 || wait > maxBlockingPopTimeout {
+		wait = maxBlockingPopTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ts.ctx, wait)
+	defer cancel()
+
+	data, err := pop(ctx, key)
+	if err == context.DeadlineExceeded {
+		return nil, notFoundErr
+	}
+
+	if err == context.Canceled {
+		return nil, serverClosingErr
+	}
+	return data, err
+}
+
+// lrangeHandler 是获取列表中一个范围的元素的处理器，参数是 key 和 8 个字节大端存储的 start 和 stop，包括 stop，负数表示从尾部开始数。
+// 返回使用 encodeBatchValues 编码的元素列表，key 不存在的话返回空的列表。
+func (ts *TCPServer) lrangeHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	start, stop, err := rangeOfArgs(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := cache.LRange(key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return encodeBatchValues(list), nil
+}
+
+// ltrimHandler 是只保留列表中一个范围的元素的处理器，参数和 lrange 命令一样。
+func (ts *TCPServer) ltrimHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+	start, stop, err := rangeOfArgs(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	return nil, cache.LTrim(key, start, stop)
+}
+
+// rangeOfArgs 解析 8 个字节大端存储的 start 和 stop，它们都是补码表示的 int64，负数表示从尾部开始数。
+func rangeOfArgs(startArg []byte, stopArg []byte) (int, int, error) {
+	start, err := uint64OfArg(startArg)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stop, err := uint64OfArg(stopArg)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(int64(start)), int(int64(stop)), nil
+}
+
+// llenHandler 是获取列表长度的处理器，参数是 key，返回 8 个字节大端存储的长度，key 不存在的话长度是 0。
+func (ts *TCPServer) llenHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	length, err := cache.LLen(key)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(length)), nil
+}
+
+// saddHandler 是往集合中添加成员的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表、key，然后是所有的成员。
+// ttl 和最长寿命的单位是纳秒，这些设置只在创建集合的时候使用，返回 8 个字节大端存储的新增成员个数。
+func (ts *TCPServer) saddHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 6 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[4])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	added, err := cache.SAdd(key, stringsOf(args[5:]), time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(added)), nil
+}
+
+// sremHandler 是从集合中移除成员的处理器，参数是 key 和所有要移除的成员，返回 8 个字节大端存储的移除个数。
+func (ts *TCPServer) sremHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.remove(args, cache.SRem)
+}
+
+// remove 解析移除成员的命令的参数，然后使用 remove 移除成员，返回 8 个字节大端存储的移除个数。
+func (ts *TCPServer) remove(args [][]byte, remove func(key string, members ...string) (int, error)) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	removed, err := remove(key, stringsOf(args[1:])...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(removed)), nil
+}
+
+// sismemberHandler 是判断成员是否在集合中的处理器，参数是 key 和成员，返回 1 个字节，1 表示在集合中，0 表示不在。
+func (ts *TCPServer) sismemberHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ok, err := cache.SIsMember(key, string(args[1]))
+	if err != nil {
+		return nil, err
+	}
+	return boolToBytes(ok), nil
+}
+
+// smembersHandler 是获取集合所有成员的处理器，参数是 key，返回使用 encodeBatchKeys 编码的成员列表，key 不存在的话返回空的列表。
+func (ts *TCPServer) smembersHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	members, err := cache.SMembers(key)
+	if err != nil {
+		return nil, err
+	}
+	return encodeBatchKeys(members), nil
+}
+
+// scardHandler 是获取集合成员个数的处理器，参数是 key，返回 8 个字节大端存储的成员个数，key 不存在的话是 0。
+func (ts *TCPServer) scardHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.count(args, cache.SCard)
+}
+
+// count 解析获取成员个数的命令的参数，然后使用 count 获取成员个数，返回 8 个字节大端存储的成员个数。
+func (ts *TCPServer) count(args [][]byte, count func(key string) (int, error)) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	n, err := count(key)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(n)), nil
+}
+
+// zaddHandler 是往有序集合中添加成员的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表、key，
+// 然后是交替出现的成员和 8 个字节大端存储的分数，分数使用 IEEE 754 格式。
+// ttl 和最长寿命的单位是纳秒，这些设置只在创建有序集合的时候使用，返回 8 个字节大端存储的新增成员个数。
+func (ts *TCPServer) zaddHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 7 || len(args)%2 == 0 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[4])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := args[5:]
+	members := make(map[string]float64, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		score, err := float64OfArg(entries[i+1])
+		if err != nil {
+			return nil, err
+		}
+		members[string(entries[i])] = score
+	}
+
+	added, err := cache.ZAdd(key, members, time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(added)), nil
+}
+
+// zincrByHandler 是加减有序集合中成员分数的处理器，参数是 8 个字节大端存储的 ttl、过期方式、最长寿命、标签列表、key、成员和增量。
+// ttl 和最长寿命的单位是纳秒，这些设置只在创建有序集合的时候使用，返回 8 个字节大端存储的加减之后的分数。
+func (ts *TCPServer) zincrByHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 7 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[4])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	ttl, err := uint64OfArg(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := setOptionsOfArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	delta, err := float64OfArg(args[6])
+	if err != nil {
+		return nil, err
+	}
+
+	score, err := cache.ZIncrBy(key, string(args[5]), delta, time.Duration(ttl), opts...)
+	if err != nil {
+		return nil, err
+	}
+	return float64ToBytes(score), nil
+}
+
+// zrangeHandler 是按照排名获取有序集合中成员的处理器，参数是 key、8 个字节大端存储的 start 和 stop，以及 1 个字节的 reverse。
+// start 和 stop 的规则和 lrange 命令一样，reverse 为 1 表示按照分数从大到小排名，返回使用 encodeZMembers 编码的成员列表。
+func (ts *TCPServer) zrangeHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 4 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	zrange := cache.ZRange
+	if len(args[3]) > 0 && args[3][0] == 1 {
+		zrange = cache.ZRevRange
+	}
+
+	start, stop, err := rangeOfArgs(args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := zrange(key, start, stop)
+	if err != nil {
+		return nil, err
+	}
+	return encodeZMembers(members), nil
+}
+
+// zrangeByScoreHandler 是按照分数获取有序集合中成员的处理器，参数是 key 和 8 个字节大端存储的 min 和 max，包括 min 和 max。
+// 返回使用 encodeZMembers 编码的成员列表，按照分数从小到大排列。
+func (ts *TCPServer) zrangeByScoreHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	min, err := float64OfArg(args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	max, err := float64OfArg(args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	members, err := cache.ZRangeByScore(key, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return encodeZMembers(members), nil
+}
+
+// zrankHandler 是获取有序集合中成员排名的处理器，参数是 key、成员和 1 个字节的 reverse，reverse 为 1 表示按照分数从大到小排名。
+// 返回 8 个字节大端存储的排名，成员不存在的话返回 notFoundErr。
+func (ts *TCPServer) zrankHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 3 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	zrank := cache.ZRank
+	if len(args[2]) > 0 && args[2][0] == 1 {
+		zrank = cache.ZRevRank
+	}
+
+	rank, ok, err := zrank(key, string(args[1]))
+	if err = updateResultOf(ok, err); err != nil {
+		return nil, err
+	}
+	return uint64ToBytes(uint64(rank)), nil
+}
+
+// zscoreHandler 是获取有序集合中成员分数的处理器，参数是 key 和成员，返回 8 个字节大端存储的分数，成员不存在的话返回 notFoundErr。
+func (ts *TCPServer) zscoreHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	key := string(args[0])
+	if err = ts.checkNode(key); err != nil {
+		return nil, err
+	}
+
+	score, ok, err := cache.ZScore(key, string(args[1]))
+	if err = updateResultOf(ok, err); err != nil {
+		return nil, err
+	}
+	return float64ToBytes(score), nil
+}
+
+// zremHandler 是从有序集合中移除成员的处理器，参数和 srem 命令一样。
+func (ts *TCPServer) zremHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.remove(args, cache.ZRem)
+}
+
+// zcardHandler 是获取有序集合成员个数的处理器，参数和 scard 命令一样。
+func (ts *TCPServer) zcardHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return ts.count(args, cache.ZCard)
+}
+
+// stringsOf 把 args 转换成字符串列表。
+func stringsOf(args [][]byte) []string {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = string(arg)
+	}
+	return strs
+}
+
+// uint64OfArg 把 8 个字节大端存储的参数转换成 uint64，长度不对的话返回 invalidArgumentErr。
+// 所有定长的参数都要使用这个方法解析，直接使用 binary.BigEndian 读取太短的参数会 panic，导致整个服务器退出。
+func uint64OfArg(arg []byte) (uint64, error) {
+	if len(arg) != 8 {
+		return 0, invalidArgumentErr
+	}
+	return binary.BigEndian.Uint64(arg), nil
+}
+
+// float64OfArg 把 8 个字节大端存储的参数按照 IEEE 754 格式转换成浮点数，长度不对的话返回 invalidArgumentErr。
+func float64OfArg(arg []byte) (float64, error) {
+	n, err := uint64OfArg(arg)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(n), nil
+}
+
+// updateResultOf 把修改数据的结果转换成错误，数据不存在的话返回 notFoundErr。
+func updateResultOf(ok bool, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return notFoundErr
+	}
+	return nil
+}
+
+// dumpHandler 是马上持久化当前节点缓存数据的处理器。
+func (ts *TCPServer) dumpHandler(args [][]byte) (body []byte, err error) {
+	return nil, ts.cache.Dump()
+}
+
+// restoreHandler 是使用历史快照替换掉当前节点缓存数据的处理器，参数是历史快照的时间戳或者快照的文件名，只能是持久化文件所在目录中的快照。
+func (ts *TCPServer) restoreHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+	return nil, ts.cache.Restore(string(args[0]))
+}
+
+// flushHandler 是清空当前节点缓存数据的处理器。
+func (ts *TCPServer) flushHandler(cache *caches.Cache, args [][]byte) (body []byte, err error) {
+	return nil, cache.Flush()
+}
+
+// namespaceHandler 是在命名空间中执行命令的处理器，参数是命名空间的名字、1 个字节的命令，后面是这个命令原本的参数。
+func (ts *TCPServer) namespaceHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 || len(args[1]) != 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	handler, ok := ts.cacheHandlers[args[1][0]]
+	if !ok {
+		return nil, commandNotSupportedErr
+	}
+
+	namespace, ok := ts.cache.Namespace(string(args[0]))
+	if !ok {
+		return nil, caches.NamespaceNotFoundErr
+	}
+	return handler(namespace, args[2:])
+}
+
+// createNamespaceHandler 是创建命名空间的处理器，参数是命名空间的名字和 JSON 格式的 caches.NamespaceOptions。
+func (ts *TCPServer) createNamespaceHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 2 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+
+	var nsOptions caches.NamespaceOptions
+	if err = json.Unmarshal(args[1], &nsOptions); err != nil {
+		return nil, err
+	}
+
+	_, err = ts.cache.CreateNamespace(string(args[0]), nsOptions)
+	return nil, err
+}
+
+// dropNamespaceHandler 是删除命名空间的处理器，参数是命名空间的名字。
+func (ts *TCPServer) dropNamespaceHandler(args [][]byte) (body []byte, err error) {
+	if len(args) < 1 {
+		return nil, commandNeedsMoreArgumentsErr
+	}
+	return nil, ts.cache.DropNamespace(string(args[0]))
+}
@@ -4,7 +4,10 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"math"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/FishGoddess/cachego"
@@ -33,6 +36,9 @@ var (
 
 	// reachMaxRetriedTimesErr 意味着重定向次数已经超过了最大限制，说明集群处于不可用状态。
 	reachedMaxRetriedTimesErr = errors.New("reached max redirect times")
+
+	// corruptedReplyErr 意味着服务端返回的数据格式不对，比如滚动升级的时候旧版本的节点返回的数据不够 8 个字节。
+	corruptedReplyErr = errors.New("reply is corrupted")
 )
 
 // TCPClient 是客户端结构。
@@ -43,6 +49,9 @@ type TCPClient struct {
 
 	// circle 存储了当前集群的一致性哈希信息，用于避免重定向。
 	circle *consistent.Consistent
+
+	// namespace 是这个客户端操作的命名空间，为空表示默认命名空间。
+	namespace string
 }
 
 // NewTCPClient 返回一个新创建的客户端实例。
@@ -165,7 +174,7 @@ func (tc *TCPClient) doCommand(client *vex.Client, command byte, args [][]byte)
 
     // 因为可能存在重定向，所以使用循环，但是不能一直重定向，所以设置了一个最大的重定向次数
 	for i := 0; i < maxRedirectTimes; i++ {
-		body, err := client.Do(command, args)
+		body, err := tc.do(client, command, args)
         
         // 判断发生的错误是不是重定向错误，如果是，就从错误中获取正确的节点地址，并拿到这个节点的客户端连接，再次执行命令
 		if err != nil && strings.HasPrefix(err.Error(), redirectPrefix) {
@@ -192,6 +201,55 @@ func (tc *TCPClient) doCommand(client *vex.Client, command byte, args [][]byte)
 	return nil, reachedMaxRetriedTimesErr
 }
 
+// do 使用 client 执行一次命令，设置了命名空间的话会使用 namespace 命令包装起来，在命名空间中执行。
+func (tc *TCPClient) do(client *vex.Client, command byte, args [][]byte) ([]byte, error) {
+	if tc.namespace == "" {
+		return client.Do(command, args)
+	}
+
+	namespaceArgs := make([][]byte, 0, len(args)+2)
+	namespaceArgs = append(namespaceArgs, []byte(tc.namespace), []byte{command})
+	return client.Do(namespaceCommand, append(namespaceArgs, args...))
+}
+
+// Namespace 返回操作名字为 name 的命名空间的客户端，name 为空表示默认命名空间。
+// 返回的客户端和当前客户端共享连接和集群信息，所以关闭任何一个都会关闭所有的连接。
+// 命名空间中不支持 Dump 和 Restore，需要使用默认命名空间的客户端，这两个命令会作用于所有的命名空间。
+func (tc *TCPClient) Namespace(name string) *TCPClient {
+	namespaceClient := *tc
+	namespaceClient.namespace = name
+	return &namespaceClient
+}
+
+// CreateNamespace 在集群的所有节点上创建命名空间，某个节点上已经存在的话会返回错误，但其他节点仍然会创建。
+func (tc *TCPClient) CreateNamespace(name string, nsOptions caches.NamespaceOptions) error {
+	data, err := json.Marshal(nsOptions)
+	if err != nil {
+		return err
+	}
+	return tc.Namespace("").doOnEachNode(createNamespaceCommand, [][]byte{[]byte(name), data})
+}
+
+// DropNamespace 删除集群所有节点上的命名空间和它的所有数据。
+func (tc *TCPClient) DropNamespace(name string) error {
+	return tc.Namespace("").doOnEachNode(dropNamespaceCommand, [][]byte{[]byte(name)})
+}
+
+// doOnEachNode 在集群的所有节点上执行命令，某个节点出错也会继续在其他节点上执行，返回最后一个错误。
+func (tc *TCPClient) doOnEachNode(command byte, args [][]byte) (lastErr error) {
+	for _, node := range tc.circle.Members() {
+		client, err := tc.getOrCreateClient(node)
+		if err == nil {
+			_, err = tc.do(client, command, args)
+		}
+
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
 // Get 获取指定 key 的数据。
 func (tc *TCPClient) Get(key string) ([]byte, error) {
 	client, err := tc.clientOf(key)
@@ -201,22 +259,869 @@ func (tc *TCPClient) Get(key string) ([]byte, error) {
 	return tc.doCommand(client, getCommand, [][]byte{[]byte(key)})
 }
 
-// Set 添加数据到缓存中。
-func (tc *TCPClient) Set(key string, value []byte, ttl int64) error {
+// Set 添加数据到缓存中，ttl 可以精确到纳秒，caches.NeverDie 表示永不过期。
+// 默认从创建开始计算有效期，可以使用 caches.Sliding 和 caches.WithMaxAge 修改过期方式。
+func (tc *TCPClient) Set(key string, value []byte, ttl time.Duration, opts ...caches.SetOption) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+    // ttl 和最长寿命统一使用纳秒传输，服务端默认也是纳秒，这里仍然带上 ns 这个单位，这样旧版本的节点也能正确处理
+	args := [][]byte{uint64ToBytes(uint64(ttl)), []byte(key), value, []byte("ns")}
+	_, err = tc.doCommand(client, setCommand, append(args, setOptionsArgsOf(opts)...))
+	return err
+}
+
+// uint64ToBytes 把 n 按照大端的方式转换成 8 个字节。
+func uint64ToBytes(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
+
+// float64ToBytes 把 f 按照 IEEE 754 格式转换成大端存储的 8 个字节。
+func float64ToBytes(f float64) []byte {
+	return uint64ToBytes(math.Float64bits(f))
+}
+
+// uint64OfReply 把服务端返回的大端存储的 8 个字节转换成整数，不够 8 个字节的话返回 corruptedReplyErr，而不是直接 panic。
+func uint64OfReply(body []byte) (uint64, error) {
+	if len(body) < 8 {
+		return 0, corruptedReplyErr
+	}
+	return binary.BigEndian.Uint64(body), nil
+}
+
+// float64OfReply 把服务端返回的大端存储的 8 个字节按照 IEEE 754 格式转换成浮点数，不够 8 个字节的话返回 corruptedReplyErr。
+func float64OfReply(body []byte) (float64, error) {
+	n, err := uint64OfReply(body)
+	return math.Float64frombits(n), err
+}
+
+// SetAt 添加数据到缓存中，并在 expireAt 这个时间点过期。
+func (tc *TCPClient) SetAt(key string, value []byte, expireAt time.Time) error {
 
 	client, err := tc.clientOf(key)
 	if err != nil {
 		return err
 	}
 
-	ttlBytes := make([]byte, 8)
-	binary.BigEndian.PutUint64(ttlBytes, uint64(ttl))
-	_, err = tc.doCommand(client, setCommand, [][]byte{
-		ttlBytes, []byte(key), value,
+	_, err = tc.doCommand(client, setAtCommand, [][]byte{
+		uint64ToBytes(uint64(expireAt.UnixNano())), []byte(key), value,
 	})
 	return err
 }
 
+// TTL 返回指定 key 的数据还剩多长时间过期，永不过期的数据返回 caches.NeverDie。
+func (tc *TCPClient) TTL(key string) (time.Duration, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, ttlCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return time.Duration(n), err
+}
+
+// Expire 把指定 key 的数据设置为从现在开始 ttl 之后过期，ttl 小于等于 0 的话会马上删除这个数据。
+func (tc *TCPClient) Expire(key string, ttl time.Duration) error {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, expireCommand, [][]byte{uint64ToBytes(uint64(ttl)), []byte(key)})
+	return err
+}
+
+// Persist 移除指定 key 的数据的所有过期设置，让它永不过期。
+func (tc *TCPClient) Persist(key string) error {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, persistCommand, [][]byte{[]byte(key)})
+	return err
+}
+
+// Touch 更新指定 key 的数据的访问时间，空闲过期的数据会重新计算空闲时间。
+func (tc *TCPClient) Touch(key string) error {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, touchCommand, [][]byte{[]byte(key)})
+	return err
+}
+
+// Incr 把指定 key 的整数加 1 并返回加完之后的结果，ttl 和 opts 只在 key 不存在需要创建数据的时候使用。
+func (tc *TCPClient) Incr(key string, ttl time.Duration, opts ...caches.SetOption) (int64, error) {
+	return tc.IncrBy(key, 1, ttl, opts...)
+}
+
+// Decr 把指定 key 的整数减 1 并返回减完之后的结果，ttl 和 opts 只在 key 不存在需要创建数据的时候使用。
+func (tc *TCPClient) Decr(key string, ttl time.Duration, opts ...caches.SetOption) (int64, error) {
+	return tc.IncrBy(key, -1, ttl, opts...)
+}
+
+// IncrBy 把指定 key 的整数加上 delta 并返回加完之后的结果，加减是在服务端原子地完成的。
+// ttl 和 opts 只在 key 不存在需要创建数据的时候使用，已经存在的数据会保留原来的有效期。
+func (tc *TCPClient) IncrBy(key string, delta int64, ttl time.Duration, opts ...caches.SetOption) (int64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := [][]byte{uint64ToBytes(uint64(delta)), []byte(key), uint64ToBytes(uint64(ttl))}
+	body, err := tc.doCommand(client, incrByCommand, append(args, setOptionsArgsOf(opts)...))
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int64(n), err
+}
+
+// setOptionsArgsOf 把 opts 转换成命令中的过期方式、最长寿命和标签列表参数，最长寿命使用纳秒传输。
+func setOptionsArgsOf(opts []caches.SetOption) [][]byte {
+	options := caches.NewSetOptions(opts...)
+	mode := absoluteMode
+	if options.Sliding {
+		mode = slidingMode
+	}
+
+	return [][]byte{[]byte(mode), uint64ToBytes(uint64(options.MaxAge)), encodeBatchKeys(options.Tags)}
+}
+
+// GetWithVersion 获取指定 key 的数据和它的版本号，版本号可以用于 CompareAndSet。
+func (tc *TCPClient) GetWithVersion(key string) ([]byte, uint64, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	body, err := tc.doCommand(client, getWithVersionCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return nil, 0, err
+	}
+	version, err := uint64OfReply(body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body[8:], version, nil
+}
+
+// CompareAndSet 只有在指定 key 的数据的版本号等于 expectedVersion 的时候才添加数据，返回新数据的版本号。
+// 版本号不一致或者数据不存在的话返回 caches.VersionMismatchErr。
+func (tc *TCPClient) CompareAndSet(key string, value []byte, expectedVersion uint64, ttl time.Duration, opts ...caches.SetOption) (uint64, error) {
+	return tc.conditionalSet(compareAndSetCommand, [][]byte{uint64ToBytes(expectedVersion)}, key, value, ttl, opts)
+}
+
+// SetIfAbsent 只有在指定 key 的数据不存在的时候才添加数据，返回新数据的版本号，数据已经存在的话返回 caches.KeyExistsErr。
+func (tc *TCPClient) SetIfAbsent(key string, value []byte, ttl time.Duration, opts ...caches.SetOption) (uint64, error) {
+	return tc.conditionalSet(setIfAbsentCommand, nil, key, value, ttl, opts)
+}
+
+// SetIfPresent 只有在指定 key 的数据存在的时候才添加数据，返回新数据的版本号，数据不存在的话返回 caches.KeyNotFoundErr。
+func (tc *TCPClient) SetIfPresent(key string, value []byte, ttl time.Duration, opts ...caches.SetOption) (uint64, error) {
+	return tc.conditionalSet(setIfPresentCommand, nil, key, value, ttl, opts)
+}
+
+// conditionalSet 执行条件添加的命令，prefix 是命令在 ttl 之前的参数，返回新数据的版本号。
+// 服务端返回的错误只有错误信息，所以条件不满足的错误需要转换回 caches 中对应的错误，方便调用者判断。
+func (tc *TCPClient) conditionalSet(command byte, prefix [][]byte, key string, value []byte, ttl time.Duration, opts []caches.SetOption) (uint64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append(prefix, uint64ToBytes(uint64(ttl)), []byte(key), value)
+	body, err := tc.doCommand(client, command, append(args, setOptionsArgsOf(opts)...))
+	if err != nil {
+		for _, conditionErr := range []error{caches.VersionMismatchErr, caches.KeyExistsErr, caches.KeyNotFoundErr} {
+			if err.Error() == conditionErr.Error() {
+				return 0, conditionErr
+			}
+		}
+		return 0, err
+	}
+	return uint64OfReply(body)
+}
+
+// HSet 把 fields 中的字段设置到 key 对应的哈希中，返回新增的字段个数。
+// ttl 和 opts 只在 key 不存在需要创建哈希的时候使用，已经存在的哈希会保留原来的有效期。
+func (tc *TCPClient) HSet(key string, fields map[string][]byte, ttl time.Duration, opts ...caches.SetOption) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([][]byte{uint64ToBytes(uint64(ttl))}, setOptionsArgsOf(opts)...)
+	args = append(args, []byte(key))
+	for field, data := range fields {
+		args = append(args, []byte(field), data)
+	}
+
+	body, err := tc.doCommand(client, hsetCommand, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// HGet 获取 key 对应的哈希中 field 字段的数据，字段不存在的话返回错误。
+func (tc *TCPClient) HGet(key string, field string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, hgetCommand, [][]byte{[]byte(key), []byte(field)})
+}
+
+// HDel 删除 key 对应的哈希中的 fields 字段，返回实际删除的字段个数，所有字段都被删除的话 key 也会被删除。
+func (tc *TCPClient) HDel(key string, fields ...string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := [][]byte{[]byte(key)}
+	for _, field := range fields {
+		args = append(args, []byte(field))
+	}
+
+	body, err := tc.doCommand(client, hdelCommand, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// HGetAll 获取 key 对应的哈希中所有的字段，key 不存在的话返回空的 map。
+func (tc *TCPClient) HGetAll(key string) (map[string][]byte, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, hgetAllCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := decodeBatchKeys(body)
+	if err != nil || len(entries)%2 != 0 {
+		return nil, corruptedBatchErr
+	}
+
+	hash := make(map[string][]byte, len(entries)/2)
+	for i := 0; i < len(entries); i += 2 {
+		hash[string(entries[i])] = entries[i+1]
+	}
+	return hash, nil
+}
+
+// LPush 把 values 依次插入到 key 对应的列表头部，返回插入之后列表的长度，所以最后一个值会在最前面。
+// ttl 和 opts 只在 key 不存在需要创建列表的时候使用，已经存在的列表会保留原来的有效期。
+func (tc *TCPClient) LPush(key string, values [][]byte, ttl time.Duration, opts ...caches.SetOption) (int, error) {
+	return tc.push(lpushCommand, key, values, ttl, opts)
+}
+
+// RPush 把 values 依次追加到 key 对应的列表尾部，返回追加之后列表的长度，其他和 LPush 一样。
+func (tc *TCPClient) RPush(key string, values [][]byte, ttl time.Duration, opts ...caches.SetOption) (int, error) {
+	return tc.push(rpushCommand, key, values, ttl, opts)
+}
+
+// push 执行插入元素的命令，返回插入之后列表的长度。
+func (tc *TCPClient) push(command byte, key string, values [][]byte, ttl time.Duration, opts []caches.SetOption) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([][]byte{uint64ToBytes(uint64(ttl))}, setOptionsArgsOf(opts)...)
+	args = append(append(args, []byte(key)), values...)
+	body, err := tc.doCommand(client, command, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// LPop 弹出 key 对应的列表头部的元素，列表为空的话返回错误。
+func (tc *TCPClient) LPop(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, lpopCommand, [][]byte{[]byte(key)})
+}
+
+// RPop 弹出 key 对应的列表尾部的元素，列表为空的话返回错误。
+func (tc *TCPClient) RPop(key string) ([]byte, error) {
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+	return tc.doCommand(client, rpopCommand, [][]byte{[]byte(key)})
+}
+
+// BLPop 弹出 key 对应的列表头部的元素，列表为空的话服务端会一直等待，直到有新的元素或者超过 timeout，超时的话返回错误。
+// timeout 为 0 表示一直等待，等待期间会占用一个单独的连接，所以不会影响这个客户端的其他操作。
+// 服务端每次最多等待 maxBlockingPopTimeout，超过的部分客户端会自动重新发送命令，所以调用者不需要关心这个限制。
+func (tc *TCPClient) BLPop(key string, timeout time.Duration) ([]byte, error) {
+	return tc.blockingPop(blpopCommand, key, timeout)
+}
+
+// BRPop 弹出 key 对应的列表尾部的元素，其他和 BLPop 一样。
+func (tc *TCPClient) BRPop(key string, timeout time.Duration) ([]byte, error) {
+	return tc.blockingPop(brpopCommand, key, timeout)
+}
+
+// blockingPop 执行阻塞弹出元素的命令，服务端每次最多等待 maxBlockingPopTimeout，所以需要等待更久的话会重新发送命令。
+func (tc *TCPClient) blockingPop(command byte, key string, timeout time.Duration) ([]byte, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		wait := maxBlockingPopTimeout
+		if timeout > 0 && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+
+		// 服务端会把 0 当作等待 maxBlockingPopTimeout，所以已经超时的话就不能再发送命令了
+		if wait <= 0 {
+			return nil, notFoundErr
+		}
+
+		body, err := tc.blockingPopOnce(command, key, wait)
+		if err == nil || err.Error() != notFoundErr.Error() || (timeout > 0 && !time.Now().Before(deadline)) {
+			return body, err
+		}
+	}
+}
+
+// blockingPopOnce 使用单独的连接执行一次阻塞弹出元素的命令，因为阻塞期间这个连接不能执行其他命令，所以用完就会关闭。
+func (tc *TCPClient) blockingPopOnce(command byte, key string, timeout time.Duration) ([]byte, error) {
+	node, err := tc.circle.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	args := [][]byte{[]byte(key), uint64ToBytes(uint64(timeout))}
+	for i := 0; i < maxRedirectTimes; i++ {
+		client, err := vex.NewClient("tcp", node)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := tc.do(client, command, args)
+		client.Close()
+		if err != nil && strings.HasPrefix(err.Error(), redirectPrefix) {
+			node = strings.TrimPrefix(err.Error(), redirectPrefix)
+			continue
+		}
+		return body, err
+	}
+	return nil, reachedMaxRetriedTimesErr
+}
+
+// LRange 返回 key 对应的列表中从 start 到 stop 的元素，包括 stop，负数的下标表示从尾部开始数，key 不存在的话返回空的列表。
+func (tc *TCPClient) LRange(key string, start int, stop int) ([][]byte, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, lrangeCommand, [][]byte{[]byte(key), uint64ToBytes(uint64(start)), uint64ToBytes(uint64(stop))})
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := decodeBatchKeys(body)
+	if list == nil && err == nil {
+		list = [][]byte{}
+	}
+	return list, err
+}
+
+// LTrim 只保留 key 对应的列表中从 start 到 stop 的元素，下标的规则和 LRange 一样，没有元素保留下来的话 key 也会被删除。
+func (tc *TCPClient) LTrim(key string, start int, stop int) error {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = tc.doCommand(client, ltrimCommand, [][]byte{[]byte(key), uint64ToBytes(uint64(start)), uint64ToBytes(uint64(stop))})
+	return err
+}
+
+// LLen 返回 key 对应的列表的长度，key 不存在的话返回 0。
+func (tc *TCPClient) LLen(key string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, llenCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// SAdd 把 members 添加到 key 对应的集合中，返回新增的成员个数。
+// ttl 和 opts 只在 key 不存在需要创建集合的时候使用，已经存在的集合会保留原来的有效期。
+func (tc *TCPClient) SAdd(key string, members []string, ttl time.Duration, opts ...caches.SetOption) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([][]byte{uint64ToBytes(uint64(ttl))}, setOptionsArgsOf(opts)...)
+	args = append(args, []byte(key))
+	for _, member := range members {
+		args = append(args, []byte(member))
+	}
+
+	body, err := tc.doCommand(client, saddCommand, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// SRem 从 key 对应的集合中移除 members，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (tc *TCPClient) SRem(key string, members ...string) (int, error) {
+	return tc.remove(sremCommand, key, members)
+}
+
+// remove 执行移除成员的命令，返回实际移除的成员个数。
+func (tc *TCPClient) remove(command byte, key string, members []string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := [][]byte{[]byte(key)}
+	for _, member := range members {
+		args = append(args, []byte(member))
+	}
+
+	body, err := tc.doCommand(client, command, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// SIsMember 返回 member 是否在 key 对应的集合中。
+func (tc *TCPClient) SIsMember(key string, member string) (bool, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return false, err
+	}
+
+	body, err := tc.doCommand(client, sismemberCommand, [][]byte{[]byte(key), []byte(member)})
+	if err != nil {
+		return false, err
+	}
+	return len(body) > 0 && body[0] == 1, nil
+}
+
+// SMembers 返回 key 对应的集合中所有的成员，按照字典序排序，key 不存在的话返回空的列表。
+func (tc *TCPClient) SMembers(key string) ([]string, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, smembersCommand, [][]byte{[]byte(key)})
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := decodeBatchKeys(body)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, len(values))
+	for i, value := range values {
+		members[i] = string(value)
+	}
+	return members, nil
+}
+
+// SCard 返回 key 对应的集合的成员个数，key 不存在的话返回 0。
+func (tc *TCPClient) SCard(key string) (int, error) {
+	return tc.count(scardCommand, key)
+}
+
+// count 执行获取成员个数的命令，返回成员个数。
+func (tc *TCPClient) count(command byte, key string) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, command, [][]byte{[]byte(key)})
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// ZAdd 把 members 中的成员和分数添加到 key 对应的有序集合中，返回新增的成员个数，已经存在的成员会更新分数。
+// ttl 和 opts 只在 key 不存在需要创建有序集合的时候使用，已经存在的有序集合会保留原来的有效期。
+func (tc *TCPClient) ZAdd(key string, members map[string]float64, ttl time.Duration, opts ...caches.SetOption) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([][]byte{uint64ToBytes(uint64(ttl))}, setOptionsArgsOf(opts)...)
+	args = append(args, []byte(key))
+	for member, score := range members {
+		args = append(args, []byte(member), float64ToBytes(score))
+	}
+
+	body, err := tc.doCommand(client, zaddCommand, args)
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// ZIncrBy 把 key 对应的有序集合中 member 的分数加上 delta，返回加上之后的分数，成员不存在的话当作分数是 0。
+// ttl 和 opts 只在 key 不存在需要创建有序集合的时候使用。
+func (tc *TCPClient) ZIncrBy(key string, member string, delta float64, ttl time.Duration, opts ...caches.SetOption) (float64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([][]byte{uint64ToBytes(uint64(ttl))}, setOptionsArgsOf(opts)...)
+	args = append(args, []byte(key), []byte(member), float64ToBytes(delta))
+	body, err := tc.doCommand(client, zincrByCommand, args)
+	if err != nil {
+		return 0, err
+	}
+	return float64OfReply(body)
+}
+
+// ZRange 返回 key 对应的有序集合中按照分数从小到大排名从 start 到 stop 的成员，包括 stop，负数的排名表示从尾部开始数。
+func (tc *TCPClient) ZRange(key string, start int, stop int) ([]caches.ZMember, error) {
+	return tc.zrange(key, start, stop, false)
+}
+
+// ZRevRange 返回 key 对应的有序集合中按照分数从大到小排名从 start 到 stop 的成员，其他和 ZRange 一样。
+func (tc *TCPClient) ZRevRange(key string, start int, stop int) ([]caches.ZMember, error) {
+	return tc.zrange(key, start, stop, true)
+}
+
+// zrange 执行按照排名获取成员的命令，reverse 为 true 表示按照分数从大到小排名。
+func (tc *TCPClient) zrange(key string, start int, stop int, reverse bool) ([]caches.ZMember, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	args := [][]byte{[]byte(key), uint64ToBytes(uint64(start)), uint64ToBytes(uint64(stop)), boolToBytes(reverse)}
+	body, err := tc.doCommand(client, zrangeCommand, args)
+	if err != nil {
+		return nil, err
+	}
+	return decodeZMembers(body)
+}
+
+// ZRangeByScore 返回 key 对应的有序集合中分数在 min 和 max 之间的成员，包括 min 和 max，按照分数从小到大排列。
+func (tc *TCPClient) ZRangeByScore(key string, min float64, max float64) ([]caches.ZMember, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.doCommand(client, zrangeByScoreCommand, [][]byte{[]byte(key), float64ToBytes(min), float64ToBytes(max)})
+	if err != nil {
+		return nil, err
+	}
+	return decodeZMembers(body)
+}
+
+// ZRank 返回 key 对应的有序集合中 member 按照分数从小到大的排名，从 0 开始，成员不存在的话返回错误。
+func (tc *TCPClient) ZRank(key string, member string) (int, error) {
+	return tc.zrank(key, member, false)
+}
+
+// ZRevRank 返回 key 对应的有序集合中 member 按照分数从大到小的排名，从 0 开始，成员不存在的话返回错误。
+func (tc *TCPClient) ZRevRank(key string, member string) (int, error) {
+	return tc.zrank(key, member, true)
+}
+
+// zrank 执行获取排名的命令，reverse 为 true 表示按照分数从大到小排名。
+func (tc *TCPClient) zrank(key string, member string, reverse bool) (int, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, zrankCommand, [][]byte{[]byte(key), []byte(member), boolToBytes(reverse)})
+	if err != nil {
+		return 0, err
+	}
+	n, err := uint64OfReply(body)
+	return int(n), err
+}
+
+// ZScore 返回 key 对应的有序集合中 member 的分数，成员不存在的话返回错误。
+func (tc *TCPClient) ZScore(key string, member string) (float64, error) {
+
+	client, err := tc.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+
+	body, err := tc.doCommand(client, zscoreCommand, [][]byte{[]byte(key), []byte(member)})
+	if err != nil {
+		return 0, err
+	}
+	return float64OfReply(body)
+}
+
+// ZRem 从 key 对应的有序集合中移除 members，返回实际移除的成员个数，所有成员都被移除的话 key 也会被删除。
+func (tc *TCPClient) ZRem(key string, members ...string) (int, error) {
+	return tc.remove(zremCommand, key, members)
+}
+
+// ZCard 返回 key 对应的有序集合的成员个数，key 不存在的话返回 0。
+func (tc *TCPClient) ZCard(key string) (int, error) {
+	return tc.count(zcardCommand, key)
+}
+
+// boolToBytes 把 b 转换成 1 个字节，true 是 1，false 是 0。
+func boolToBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// BatchResult 是批量操作中单个 key 的处理结果。
+type BatchResult struct {
+
+	// Key 是这个结果对应的 key。
+	Key string
+
+	// Value 是获取到的数据，只有 MGet 才会有。
+	Value []byte
+
+	// Err 是处理这个 key 时发生的错误，为空表示处理成功。
+	Err error
+}
+
+// MGet 批量获取数据，返回的结果和 keys 的顺序一致，每个 key 的错误都记录在各自的结果中。
+func (tc *TCPClient) MGet(keys []string) []BatchResult {
+	return tc.doBatch(mgetCommand, keys, func(indexes []int) [][]byte {
+		args := make([][]byte, len(indexes))
+		for i, index := range indexes {
+			args[i] = []byte(keys[index])
+		}
+		return args
+	})
+}
+
+// MSet 批量添加数据，所有数据都使用同样的 ttl 和过期方式，返回的结果按照 key 排序。
+func (tc *TCPClient) MSet(entries map[string][]byte, ttl time.Duration, opts ...caches.SetOption) []BatchResult {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	optionsArgs := setOptionsArgsOf(opts)
+	return tc.doBatch(msetCommand, keys, func(indexes []int) [][]byte {
+		args := make([][]byte, 0, 1+len(optionsArgs)+2*len(indexes))
+		args = append(args, uint64ToBytes(uint64(ttl)))
+		args = append(args, optionsArgs...)
+		for _, index := range indexes {
+			args = append(args, []byte(keys[index]), entries[keys[index]])
+		}
+		return args
+	})
+}
+
+// MDelete 批量删除数据，返回的结果和 keys 的顺序一致，每个 key 的错误都记录在各自的结果中。
+func (tc *TCPClient) MDelete(keys []string) []BatchResult {
+	return tc.doBatch(mdeleteCommand, keys, func(indexes []int) [][]byte {
+		args := make([][]byte, len(indexes))
+		for i, index := range indexes {
+			args[i] = []byte(keys[index])
+		}
+		return args
+	})
+}
+
+// doBatch 把 keys 按照所属的节点分组，然后并发地在每个节点上执行批量命令，合并之后的结果和 keys 的顺序一致。
+// argsOf 返回一组 key 的命令参数，indexes 是这组 key 在 keys 中的下标。
+// 因为一致性哈希的信息可能不准，服务端会对不属于它的 key 单独返回重定向错误，这些 key 会再按照新的节点分组重试。
+func (tc *TCPClient) doBatch(command byte, keys []string, argsOf func(indexes []int) [][]byte) []BatchResult {
+	results := make([]BatchResult, len(keys))
+	groups := make(map[string][]int)
+	for i, key := range keys {
+		results[i].Key = key
+		node, err := tc.circle.Get(key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		groups[node] = append(groups[node], i)
+	}
+
+	for i := 0; i < maxRedirectTimes && len(groups) > 0; i++ {
+		redirects := make(map[string][]int)
+		lock := &sync.Mutex{}
+		wg := &sync.WaitGroup{}
+		for node, indexes := range groups {
+			wg.Add(1)
+			go func(node string, indexes []int) {
+				defer wg.Done()
+
+				// 每个 goroutine 只会写入自己这组 key 的结果，所以不需要加锁，只有记录重定向的时候需要
+				batchResults, err := tc.doBatchOnNode(node, command, keys, indexes, argsOf(indexes))
+				for j, index := range indexes {
+					if err != nil {
+						results[index].Err = err
+						continue
+					}
+
+					if errMsg := batchResults[j].Error; strings.HasPrefix(errMsg, redirectPrefix) {
+						lock.Lock()
+						redirectNode := strings.TrimPrefix(errMsg, redirectPrefix)
+						redirects[redirectNode] = append(redirects[redirectNode], index)
+						lock.Unlock()
+					} else if errMsg != "" {
+						results[index].Err = errors.New(errMsg)
+					} else {
+						results[index].Value = batchResults[j].Value
+					}
+				}
+			}(node, indexes)
+		}
+		wg.Wait()
+		groups = redirects
+	}
+
+	// 重定向达到了最大次数的 key，直接返回错误
+	for _, indexes := range groups {
+		for _, index := range indexes {
+			results[index].Err = reachedMaxRetriedTimesErr
+		}
+	}
+	return results
+}
+
+// doBatchOnNode 在 node 上执行批量命令，返回这组 key 的结果。
+func (tc *TCPClient) doBatchOnNode(node string, command byte, keys []string, indexes []int, args [][]byte) ([]batchResult, error) {
+	client, err := tc.getOrCreateClient(node)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := tc.do(client, command, args)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeKeys := make([]string, len(indexes))
+	for i, index := range indexes {
+		nodeKeys[i] = keys[index]
+	}
+	return decodeBatchResults(body, nodeKeys)
+}
+
+// Scan 从 cursor 开始遍历整个集群中匹配 pattern 的 key，返回遍历到的 key 和下一次遍历使用的 cursor，返回的 cursor 为 0 表示遍历完了。
+// 集群的节点按照地址排序之后一个一个遍历，cursor 的高 32 位是节点的下标，低 32 位是这个节点的 cursor。
+// 遍历期间集群的节点发生变化的话，可能会漏掉或者重复返回一些 key。
+func (tc *TCPClient) Scan(cursor uint64, pattern string, count int) ([]string, uint64, error) {
+	nodes := tc.circle.Members()
+	sort.Strings(nodes)
+
+	var keys []string
+	for nodeIndex := int(cursor >> 32); nodeIndex < len(nodes); nodeIndex++ {
+		client, err := tc.getOrCreateClient(nodes[nodeIndex])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		body, err := tc.do(client, scanCommand, [][]byte{
+			uint64ToBytes(cursor & math.MaxUint32), []byte(pattern), uint64ToBytes(uint64(count - len(keys))),
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if len(body) < 8 {
+			return nil, 0, corruptedBatchErr
+		}
+
+		nodeKeys, err := decodeBatchKeys(body[8:])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for _, key := range nodeKeys {
+			keys = append(keys, string(key))
+		}
+
+		// 这个节点还没有遍历完，下次继续遍历这个节点，否则从下一个节点的开头继续
+		if nodeCursor := binary.BigEndian.Uint64(body); nodeCursor != 0 {
+			return keys, uint64(nodeIndex)<<32 | nodeCursor, nil
+		}
+
+		cursor = uint64(nodeIndex+1) << 32
+		if len(keys) >= count {
+			if nodeIndex+1 >= len(nodes) {
+				return keys, 0, nil
+			}
+			return keys, cursor, nil
+		}
+	}
+	return keys, 0, nil
+}
+
 // Delete 删除指定 key 的数据。
 func (tc *TCPClient) Delete(key string) error {
 
@@ -240,7 +1145,7 @@ func (tc *TCPClient) Status() (*caches.Status, error) {
 		if err != nil {
 			continue
 		}
-		body, err := client.Do(statusCommand, nil)
+		body, err := tc.do(client, statusCommand, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -249,13 +1154,90 @@ func (tc *TCPClient) Status() (*caches.Status, error) {
 		if err != nil {
 			return nil, err
 		}
-		totalStatus.Count += status.Count
-		totalStatus.KeySize += status.KeySize
-		totalStatus.ValueSize += status.ValueSize
+		totalStatus.Merge(status)
 	}
 	return totalStatus, nil
 }
 
+// Dump 让集群中的所有节点马上持久化缓存数据。
+func (tc *TCPClient) Dump() error {
+	return tc.doOnAllNodes(dumpCommand, nil)
+}
+
+// Restore 让集群中的所有节点使用 snapshot 对应的历史快照替换掉缓存数据。
+// snapshot 是历史快照文件名中的时间戳或者快照的文件名，节点只会使用持久化文件所在目录中的快照。
+func (tc *TCPClient) Restore(snapshot string) error {
+	return tc.doOnAllNodes(restoreCommand, [][]byte{[]byte(snapshot)})
+}
+
+// Flush 清空集群中所有节点的缓存数据。
+func (tc *TCPClient) Flush() error {
+	return tc.doOnAllNodes(flushCommand, nil)
+}
+
+// DeleteByPrefix 删除整个集群中所有以 prefix 开头的数据，返回删除的数量。
+func (tc *TCPClient) DeleteByPrefix(prefix string) (int, error) {
+	return tc.sumOnAllNodes(deleteByPrefixCommand, [][]byte{[]byte(prefix)})
+}
+
+// DeleteByTag 删除整个集群中所有带有 tag 这个标签的数据，返回删除的数量。
+func (tc *TCPClient) DeleteByTag(tag string) (int, error) {
+	return tc.sumOnAllNodes(deleteByTagCommand, [][]byte{[]byte(tag)})
+}
+
+// sumOnAllNodes 并发地在集群的所有节点上执行命令，返回所有节点响应的数量之和，响应是 8 个字节大端存储的数量。
+// 某个节点出错的话，其他节点的命令还是会执行完，返回的数量是执行成功的节点的数量之和，错误是其中一个节点的错误。
+func (tc *TCPClient) sumOnAllNodes(command byte, args [][]byte) (int, error) {
+	sum := 0
+	var lastErr error
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for _, node := range tc.circle.Members() {
+		wg.Add(1)
+		go func(node string) {
+			defer wg.Done()
+			client, err := tc.getOrCreateClient(node)
+			if err != nil {
+				lock.Lock()
+				lastErr = err
+				lock.Unlock()
+				return
+			}
+
+			body, err := tc.do(client, command, args)
+			lock.Lock()
+			defer lock.Unlock()
+			if err == nil && len(body) < 8 {
+				err = corruptedBatchErr
+			}
+
+			if err != nil {
+				lastErr = err
+				return
+			}
+			sum += int(binary.BigEndian.Uint64(body))
+		}(node)
+	}
+	wg.Wait()
+	return sum, lastErr
+}
+
+// doOnAllNodes 在集群的所有节点上执行命令，遇到错误就马上返回。
+func (tc *TCPClient) doOnAllNodes(command byte, args [][]byte) error {
+	nodes := tc.circle.Members()
+	for _, node := range nodes {
+		client, err := tc.getOrCreateClient(node)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tc.do(client, command, args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Nodes 返回集群的节点信息。
 func (tc *TCPClient) Nodes() ([]string, error) {
 	return tc.nodes()